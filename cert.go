@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// loadCert reads an OpenSSH user certificate from its contents or its
+// path, it is nil when neither is set. A certificate outside of its
+// validity period is rejected before any dial.
+func loadCert(name, cert, path string) (*ssh.Certificate, error) {
+	data := []byte(cert)
+	if len(bytes.TrimSpace(data)) == 0 {
+		if path == "" {
+			return nil, nil
+		}
+
+		var err error
+		if data, err = os.ReadFile(expandHome(path)); err != nil {
+			return nil, fmt.Errorf("error: can't read %s: %w", name, err)
+		}
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("error: can't parse %s: %w", name, err)
+	}
+
+	c, ok := key.(*ssh.Certificate)
+	if !ok || c.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("error: %s is not an OpenSSH user certificate", name)
+	}
+
+	now := time.Now()
+	if c.ValidBefore != ssh.CertTimeInfinity && uint64(now.Unix()) >= c.ValidBefore {
+		return nil, fmt.Errorf(
+			"error: %s %q expired at %s",
+			name,
+			c.KeyId,
+			time.Unix(int64(c.ValidBefore), 0).UTC().Format(time.RFC3339),
+		)
+	}
+	if uint64(now.Unix()) < c.ValidAfter {
+		return nil, fmt.Errorf(
+			"error: %s %q is not valid before %s",
+			name,
+			c.KeyId,
+			time.Unix(int64(c.ValidAfter), 0).UTC().Format(time.RFC3339),
+		)
+	}
+
+	return c, nil
+}
+
+// certSigners pairs the certificate with the signer of its key. The plain
+// signers are kept after the certificate, for servers that ignore it.
+func certSigners(cert *ssh.Certificate, signers []ssh.Signer) ([]ssh.Signer, error) {
+	if cert == nil {
+		return signers, nil
+	}
+
+	for _, s := range signers {
+		if bytes.Equal(s.PublicKey().Marshal(), cert.Key.Marshal()) {
+			certSigner, err := ssh.NewCertSigner(cert, s)
+			if err != nil {
+				return nil, err
+			}
+			return append([]ssh.Signer{certSigner}, signers...), nil
+		}
+	}
+
+	return nil, errCertKeyMismatch
+}
+
+// parseHostCAKeys reads the CA public keys of name, one per line in the
+// authorized_keys format, an @cert-authority marker and host patterns
+// are allowed as in known_hosts.
+func parseHostCAKeys(name, data string) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if rest, ok := strings.CutPrefix(line, "@cert-authority"); ok {
+			// skip the host patterns of a known_hosts line
+			if fields := strings.Fields(rest); len(fields) > 2 {
+				line = strings.Join(fields[1:], " ")
+			}
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			return nil, fmt.Errorf("error: can't parse %s: %w", name, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// certAlgorithmsFirst puts the host certificate algorithms in front, the
+// server only presents its certificate when the client asks for one.
+func certAlgorithmsFirst(algorithms []string) []string {
+	if algorithms == nil {
+		algorithms = append(ssh.SupportedAlgorithms().HostKeys, ssh.KeyAlgoRSA)
+	}
+
+	var certs []string
+	for _, algorithm := range ssh.SupportedAlgorithms().HostKeys {
+		if strings.Contains(algorithm, "-cert-") {
+			certs = append(certs, algorithm)
+		}
+	}
+
+	return uniq(append(certs, algorithms...))
+}
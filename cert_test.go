@@ -0,0 +1,203 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	s, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return s
+}
+
+// newCert signs a certificate of key, valid between after and before.
+func newCert(t *testing.T, key ssh.PublicKey, certType uint32, after, before uint64) string {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             key,
+		KeyId:           "deploy",
+		CertType:        certType,
+		ValidPrincipals: []string{"drone-scp"},
+		ValidAfter:      after,
+		ValidBefore:     before,
+	}
+	require.NoError(t, cert.SignCert(rand.Reader, newSigner(t)))
+	return string(ssh.MarshalAuthorizedKey(cert))
+}
+
+func TestLoadCert(t *testing.T) {
+	key := newSigner(t)
+	now := uint64(time.Now().Unix())
+
+	cert, err := loadCert("ssh_cert", "", "")
+	assert.NoError(t, err)
+	assert.Nil(t, cert)
+
+	valid := newCert(t, key.PublicKey(), ssh.UserCert, now-60, now+3600)
+	cert, err = loadCert("ssh_cert", valid, "")
+	require.NoError(t, err)
+	assert.Equal(t, "deploy", cert.KeyId)
+
+	path := filepath.Join(t.TempDir(), "id_ed25519-cert.pub")
+	require.NoError(t, os.WriteFile(path, []byte(valid), 0o600))
+	cert, err = loadCert("ssh_cert", "", path)
+	require.NoError(t, err)
+	assert.Equal(t, "deploy", cert.KeyId)
+
+	_, err = loadCert("ssh_cert", newCert(t, key.PublicKey(), ssh.UserCert, 0, ssh.CertTimeInfinity), "")
+	assert.NoError(t, err)
+
+	_, err = loadCert("ssh_cert", newCert(t, key.PublicKey(), ssh.UserCert, now-7200, now-3600), "")
+	assert.ErrorContains(t, err, `ssh_cert "deploy" expired at`)
+
+	_, err = loadCert("proxy_ssh_cert", newCert(t, key.PublicKey(), ssh.UserCert, now+3600, now+7200), "")
+	assert.ErrorContains(t, err, `proxy_ssh_cert "deploy" is not valid before`)
+
+	_, err = loadCert("ssh_cert", newCert(t, key.PublicKey(), ssh.HostCert, 0, ssh.CertTimeInfinity), "")
+	assert.ErrorContains(t, err, "not an OpenSSH user certificate")
+
+	_, err = loadCert("ssh_cert", string(ssh.MarshalAuthorizedKey(key.PublicKey())), "")
+	assert.ErrorContains(t, err, "not an OpenSSH user certificate")
+
+	_, err = loadCert("ssh_cert", "", filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorContains(t, err, "can't read ssh_cert")
+}
+
+func TestCertSigners(t *testing.T) {
+	key := newSigner(t)
+	other := newSigner(t)
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(
+		[]byte(newCert(t, key.PublicKey(), ssh.UserCert, 0, ssh.CertTimeInfinity)),
+	)
+	require.NoError(t, err)
+	cert := pub.(*ssh.Certificate)
+
+	signers, err := certSigners(nil, []ssh.Signer{key})
+	require.NoError(t, err)
+	assert.Len(t, signers, 1)
+
+	signers, err = certSigners(cert, []ssh.Signer{other, key})
+	require.NoError(t, err)
+	require.Len(t, signers, 3)
+	assert.Equal(t, ssh.CertAlgoED25519v01, signers[0].PublicKey().Type())
+
+	_, err = certSigners(cert, []ssh.Signer{other})
+	assert.Equal(t, errCertKeyMismatch, err)
+}
+
+func TestExpiredCertFailsBeforeDial(t *testing.T) {
+	key := newSigner(t)
+	now := uint64(time.Now().Unix())
+
+	plugin := Plugin{
+		Config: Config{
+			Host:     []string{"localhost"},
+			Username: "drone-scp",
+			KeyPath:  "./tests/.ssh/id_rsa",
+			Cert:     newCert(t, key.PublicKey(), ssh.UserCert, now-7200, now-3600),
+			Script:   []string{"whoami"},
+		},
+	}
+
+	result, err := plugin.Execute()
+	assert.Nil(t, result)
+	assert.ErrorContains(t, err, "expired at")
+}
+
+func TestParseHostCAKeys(t *testing.T) {
+	ca := newSigner(t)
+	line := string(ssh.MarshalAuthorizedKey(ca.PublicKey()))
+
+	keys, err := parseHostCAKeys("host_ca_key", "")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	keys, err = parseHostCAKeys("host_ca_key", "# CA\n"+line+"@cert-authority *.example.com "+line)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, ca.PublicKey().Marshal(), keys[0].Marshal())
+	assert.Equal(t, ca.PublicKey().Marshal(), keys[1].Marshal())
+
+	_, err = parseHostCAKeys("proxy_host_ca_key", "ssh-ed25519 !!!")
+	assert.ErrorContains(t, err, "can't parse proxy_host_ca_key")
+}
+
+func TestCertAlgorithmsFirst(t *testing.T) {
+	algorithms := certAlgorithmsFirst(nil)
+	assert.Equal(t, ssh.CertAlgoRSASHA256v01, algorithms[0])
+	assert.Contains(t, algorithms, ssh.KeyAlgoED25519)
+
+	algorithms = certAlgorithmsFirst([]string{ssh.KeyAlgoED25519})
+	assert.Equal(t, ssh.KeyAlgoED25519, algorithms[len(algorithms)-1])
+	assert.NotContains(t, algorithms, ssh.KeyAlgoRSA)
+}
+
+func TestHostKeyCallbackHostCA(t *testing.T) {
+	ca := newSigner(t)
+	host := newSigner(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	now := uint64(time.Now().Unix())
+
+	hostCert := func(principal string, after, before uint64) ssh.PublicKey {
+		cert := &ssh.Certificate{
+			Key:             host.PublicKey(),
+			CertType:        ssh.HostCert,
+			ValidPrincipals: []string{principal},
+			ValidAfter:      after,
+			ValidBefore:     before,
+		}
+		require.NoError(t, cert.SignCert(rand.Reader, ca))
+		return cert
+	}
+
+	callback := hostKeyCallback(endpoint{HostCAKeys: []ssh.PublicKey{ca.PublicKey()}}, nil)
+
+	assert.NoError(t, callback("foo.com:22", remote, hostCert("foo.com", now-60, now+3600)))
+	assert.ErrorContains(
+		t,
+		callback("bar.com:22", remote, hostCert("foo.com", now-60, now+3600)),
+		"not in the set of valid principals",
+	)
+	assert.ErrorContains(
+		t,
+		callback("foo.com:22", remote, hostCert("foo.com", now-7200, now-3600)),
+		"expired",
+	)
+	assert.ErrorContains(
+		t,
+		callback("foo.com:22", remote, host.PublicKey()),
+		"is not a certificate signed by the host CA",
+	)
+
+	other := newSigner(t)
+	assert.ErrorContains(
+		t,
+		hostKeyCallback(endpoint{HostCAKeys: []ssh.PublicKey{other.PublicKey()}}, nil)(
+			"foo.com:22",
+			remote,
+			hostCert("foo.com", now-60, now+3600),
+		),
+		"no authorities",
+	)
+
+	// plain host keys fall back to the fingerprint
+	callback = hostKeyCallback(endpoint{
+		HostCAKeys:  []ssh.PublicKey{ca.PublicKey()},
+		Fingerprint: ssh.FingerprintSHA256(host.PublicKey()),
+	}, nil)
+	assert.NoError(t, callback("foo.com:22", remote, host.PublicKey()))
+}
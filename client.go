@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	easyssh "github.com/appleboy/easyssh-proxy"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// defaultDialTimeout bounds the dial from the proxy to the target
+// when Config.Timeout is not set.
+const defaultDialTimeout = 60 * time.Second
+
+// endpoint is a single SSH server of a connection, the target or its proxy.
+type endpoint struct {
+	Host string
+	// IP is dialed instead of Host when set, the host key is verified
+	// for Host.
+	IP                string
+	Port              string
+	User              string
+	Password          string
+	Key               string
+	KeyPath           string
+	Keys              []string
+	KeyPaths          []string
+	Passphrase        string
+	Fingerprint       string
+	Cert              *ssh.Certificate
+	HostCAKeys        []ssh.PublicKey
+	Answers           []answer
+	Protocol          easyssh.Protocol
+	Timeout           time.Duration
+	Ciphers           []string
+	UseInsecureCipher bool
+	// AgentForward is set on the target, a changed host key fails
+	// its connection instead of reaching the agent.
+	AgentForward bool
+}
+
+func (e endpoint) address() string {
+	return net.JoinHostPort(e.Host, e.Port)
+}
+
+// dialAddress returns the host:port to dial.
+func (e endpoint) dialAddress() string {
+	if e.IP != "" {
+		return net.JoinHostPort(e.IP, e.Port)
+	}
+	return e.address()
+}
+
+func (e endpoint) network() string {
+	if e.Protocol == "" {
+		return string(easyssh.PROTOCOL_TCP)
+	}
+	return string(e.Protocol)
+}
+
+// clientConfig builds the client config of e. The private keys, the
+// certificate and the ssh-agent identities are offered first, in this
+// order, keyboard-interactive and the password come last. creds counts
+// the offered credentials and receives the one the server accepted. The
+// returned closer, when not nil, releases the ssh-agent connection once
+// the handshake is done.
+func (p Plugin) clientConfig(
+	e endpoint,
+	hostKeys *knownHosts,
+	creds *credentials,
+) (*ssh.ClientConfig, io.Closer, error) {
+	signers := []ssh.Signer{}
+	for _, key := range e.privateKeys() {
+		s, err := signer(key.data, e.Passphrase)
+		if err != nil {
+			log.Printf("ssh.ParsePrivateKey %s: %v\n", key.name, err)
+			continue
+		}
+		signers = append(signers, namedSigner(s, key.name, creds))
+	}
+
+	signers, err := certSigners(e.Cert, signers)
+	if err != nil {
+		return nil, nil, err
+	}
+	if e.Cert != nil {
+		signers[0] = namedSigner(signers[0], fmt.Sprintf("certificate %q", e.Cert.KeyId), creds)
+	}
+
+	agentClient, closer, err := p.agent()
+	if err != nil {
+		return nil, nil, err
+	}
+	creds.offered = len(signers)
+	if e.Password != "" || len(e.Answers) > 0 {
+		creds.offered++
+	}
+
+	// A single publickey method, the client never tries a method twice.
+	auths := []ssh.AuthMethod{}
+	if len(signers) > 0 || agentClient != nil {
+		auths = append(auths, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			if agentClient == nil {
+				return signers, nil
+			}
+
+			agentSigners, err := agentClient.Signers()
+			if err != nil {
+				log.Printf("ssh-agent: %v\n", err)
+				return signers, nil
+			}
+
+			// the callback runs once per connection
+			creds.offered += len(agentSigners)
+			all := slices.Clone(signers)
+			for _, s := range agentSigners {
+				name := "ssh-agent key " + ssh.FingerprintSHA256(s.PublicKey())
+				if key, ok := s.PublicKey().(*agent.Key); ok && key.Comment != "" {
+					name = "ssh-agent key " + key.Comment
+				}
+				all = append(all, namedSigner(s, name, creds))
+			}
+			return all, nil
+		}))
+	}
+	if e.Password != "" || len(e.Answers) > 0 {
+		auths = append(auths, ssh.KeyboardInteractive(keyboardInteractive(e.Password, e.Answers, creds)))
+	}
+	if e.Password != "" {
+		auths = append(auths, ssh.PasswordCallback(func() (string, error) {
+			if creds.hostKeyChanged {
+				return "", errHostKeyChanged
+			}
+			creds.used = "password"
+			return e.Password, nil
+		}))
+	}
+
+	c := ssh.Config{}
+	if e.UseInsecureCipher {
+		c.SetDefaults()
+		c.Ciphers = append(c.Ciphers, "aes128-cbc", "aes192-cbc", "aes256-cbc", "3des-cbc")
+		c.KeyExchanges = append(
+			c.KeyExchanges,
+			"diffie-hellman-group-exchange-sha1",
+			"diffie-hellman-group-exchange-sha256",
+		)
+	}
+	if len(e.Ciphers) > 0 {
+		c.Ciphers = append(c.Ciphers, e.Ciphers...)
+	}
+
+	check := hostKeyCallback(e, hostKeys)
+	config := &ssh.ClientConfig{
+		Config:  c,
+		Timeout: e.Timeout,
+		User:    e.User,
+		Auth:    auths,
+		// the callback runs before the authentication
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			err := check(hostname, remote, key)
+			if errors.Is(err, errHostKeyChanged) && !e.AgentForward {
+				creds.hostKeyChanged = true
+				return nil
+			}
+			return err
+		},
+	}
+	if hostKeys != nil {
+		config.HostKeyAlgorithms = hostKeys.algorithms(e.address())
+	}
+	if len(e.HostCAKeys) > 0 {
+		config.HostKeyAlgorithms = certAlgorithmsFirst(config.HostKeyAlgorithms)
+	}
+
+	return config, closer, nil
+}
+
+// agent connects to the ssh-agent listening on SSH_AUTH_SOCK. The agent
+// is optional unless Config.UseAgent is set.
+func (p Plugin) agent() (agent.ExtendedAgent, io.Closer, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		if p.Config.UseAgent {
+			return nil, nil, errMissingAgent
+		}
+		return nil, nil, nil
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		if p.Config.UseAgent {
+			return nil, nil, fmt.Errorf("error: can't connect to the ssh-agent: %w", err)
+		}
+		return nil, nil, nil
+	}
+
+	return agent.NewClient(conn), conn, nil
+}
+
+// hostKeyCallback checks the fingerprint when one is set and the
+// known_hosts entries when they are configured, without either every
+// host key is accepted. With host CA keys, a host certificate signed by
+// one of them is trusted and a plain host key needs one of the other checks.
+func hostKeyCallback(e endpoint, hostKeys *knownHosts) ssh.HostKeyCallback {
+	check := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if e.Fingerprint != "" && ssh.FingerprintSHA256(key) != e.Fingerprint {
+			return fmt.Errorf(
+				"ssh: host key fingerprint mismatch for %s: expected %s, the server presented %s",
+				hostname,
+				e.Fingerprint,
+				ssh.FingerprintSHA256(key),
+			)
+		}
+		if hostKeys != nil {
+			return hostKeys.check(hostname, remote, key)
+		}
+		return nil
+	}
+
+	if len(e.HostCAKeys) == 0 {
+		return check
+	}
+
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(auth ssh.PublicKey, _ string) bool {
+			return slices.ContainsFunc(e.HostCAKeys, func(ca ssh.PublicKey) bool {
+				return bytes.Equal(ca.Marshal(), auth.Marshal())
+			})
+		},
+		HostKeyFallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if e.Fingerprint == "" && hostKeys == nil {
+				return fmt.Errorf(
+					"ssh: the host key of %s (%s) is not a certificate signed by the host CA",
+					hostname,
+					ssh.FingerprintSHA256(key),
+				)
+			}
+			return check(hostname, remote, key)
+		},
+	}
+
+	return checker.CheckHostKey
+}
+
+// forwardAgent makes the local ssh-agent available to the remote session,
+// without one an agent holding the private key of the target is forwarded.
+func (p Plugin) forwardAgent(client *ssh.Client, session *ssh.Session, t target) error {
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if err := agent.ForwardToRemote(client, socket); err != nil {
+			return fmt.Errorf("error: can't forward the ssh-agent: %w", err)
+		}
+	} else {
+		keyring := agent.NewKeyring()
+		for _, key := range t.endpoint(p.Config).privateKeys() {
+			raw, err := ssh.ParseRawPrivateKey(key.data)
+			var missing *ssh.PassphraseMissingError
+			if t.Passphrase != "" && errors.As(err, &missing) {
+				raw, err = ssh.ParseRawPrivateKeyWithPassphrase(key.data, []byte(t.Passphrase))
+			}
+			if err != nil {
+				return fmt.Errorf("error: can't forward the private key %s: %w", key.name, err)
+			}
+			if err := keyring.Add(agent.AddedKey{PrivateKey: raw, Comment: key.name}); err != nil {
+				return fmt.Errorf("error: can't forward the private key %s: %w", key.name, err)
+			}
+		}
+
+		if keys, _ := keyring.List(); len(keys) == 0 {
+			return errAgentForward
+		}
+		if err := agent.ForwardToAgent(client, keyring); err != nil {
+			return fmt.Errorf("error: can't forward the ssh-agent: %w", err)
+		}
+	}
+
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		return fmt.Errorf("error: agent forwarding was refused: %w", err)
+	}
+
+	return nil
+}
+
+// dial connects and authenticates to the target, through its jump hosts
+// if any. Each hop is dialed from the previous one and closed with it.
+func (p Plugin) dial(t target) (*ssh.Client, error) {
+	hops := t.hops()
+
+	var via *ssh.Client
+	for i, hop := range hops {
+		prefix := "proxy "
+		if len(hops) > 1 {
+			prefix = fmt.Sprintf("jump host %d ", i+1)
+		}
+
+		client, err := p.connect(t, via, hop, prefix)
+		if err != nil {
+			if len(hops) > 1 {
+				err = fmt.Errorf("jump host %d (%s): %w", i+1, hop.address(), err)
+			}
+			return nil, err
+		}
+		via = client
+	}
+
+	return p.connect(t, via, t.endpoint(p.Config), "")
+}
+
+// connect authenticates to the hop e of t, through the client of the
+// previous hop, which is closed when the returned client or the
+// connection attempt ends.
+func (p Plugin) connect(t target, via *ssh.Client, e endpoint, prefix string) (*ssh.Client, error) {
+	var creds credentials
+	config, closer, err := p.clientConfig(e, t.hostKeys, &creds)
+	if err != nil {
+		if via != nil {
+			via.Close()
+		}
+		return nil, err
+	}
+	if closer != nil {
+		defer closer.Close()
+	}
+
+	if via == nil {
+		timeout := e.Timeout
+		if timeout == 0 {
+			timeout = p.Config.Timeout
+		}
+		conn, err := t.dialFirst(e, timeout)
+		if err != nil {
+			return nil, err
+		}
+
+		// a proxy command may never answer, such as one waiting for a
+		// login, Close kills it when the handshake runs out of time
+		command, isCommand := conn.(*commandConn)
+		if isCommand {
+			if timeout == 0 {
+				timeout = defaultDialTimeout
+			}
+			_ = command.SetDeadline(time.Now().Add(timeout))
+		}
+
+		ncc, chans, reqs, err := ssh.NewClientConn(conn, e.address(), config)
+		if err != nil {
+			conn.Close()
+			if isCommand && errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, fmt.Errorf("proxy_command: no SSH handshake within %s: %w", timeout, err)
+			}
+			return nil, err
+		}
+		if isCommand {
+			_ = command.SetDeadline(time.Time{})
+		}
+
+		p.debugAuth(t.Alias, prefix, creds)
+		return ssh.NewClient(ncc, chans, reqs), nil
+	}
+
+	timeout := p.Config.Timeout
+	if timeout == 0 {
+		timeout = defaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := via.DialContext(ctx, e.network(), e.dialAddress())
+	if err != nil {
+		via.Close()
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %v", easyssh.ErrProxyDialTimeout, ctx.Err())
+		}
+		return nil, err
+	}
+
+	ncc, chans, reqs, err := ssh.NewClientConn(conn, e.address(), config)
+	if err != nil {
+		conn.Close()
+		via.Close()
+		return nil, err
+	}
+
+	p.debugAuth(t.Alias, prefix, creds)
+	client := ssh.NewClient(ncc, chans, reqs)
+	go func() {
+		_ = client.Wait()
+		via.Close()
+	}()
+
+	return client, nil
+}
+
+// dialFirst opens the connection of the first hop of t: the proxy
+// command, the network proxy or a direct connection.
+func (t target) dialFirst(e endpoint, timeout time.Duration) (net.Conn, error) {
+	if t.ProxyCommand != "" {
+		return dialCommand(t.ProxyCommand, e)
+	}
+	return t.networkProxy.dial(e.network(), e.dialAddress(), timeout)
+}
+
+// debugAuth tells which credential the server accepted, when there was
+// more than one to try.
+func (p Plugin) debugAuth(host, prefix string, creds credentials) {
+	if p.Config.Debug && creds.used != "" && creds.offered > 1 {
+		p.log(host, "🔑 "+prefix+"authenticated with "+creds.used)
+	}
+}
+
+// stream connects to the target and starts the command. Stdout and stderr
+// are sent line by line, done receives true when the command finished and
+// false when it ran past Config.CommandTimeout. errChan receives the exit
+// error of the command before done.
+func (p Plugin) stream(
+	t target,
+	command string,
+) (<-chan string, <-chan string, <-chan bool, <-chan error, error) {
+	stdoutChan := make(chan string)
+	stderrChan := make(chan string)
+	doneChan := make(chan bool)
+	errChan := make(chan error)
+
+	client, err := p.dial(t)
+	if err != nil {
+		return stdoutChan, stderrChan, doneChan, errChan, err
+	}
+
+	session, err := client.NewSession()
+	if err != nil {
+		client.Close()
+		return stdoutChan, stderrChan, doneChan, errChan, err
+	}
+
+	if p.Config.AgentForward {
+		if err := p.forwardAgent(client, session, t); err != nil {
+			session.Close()
+			client.Close()
+			return stdoutChan, stderrChan, doneChan, errChan, err
+		}
+	}
+
+	if p.Config.RequireTty {
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          0,     // disable echoing
+			ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
+			ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
+		}
+		if err := session.RequestPty("xterm", 80, 40, modes); err != nil {
+			session.Close()
+			client.Close()
+			return stdoutChan, stderrChan, doneChan, errChan, err
+		}
+	}
+
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		client.Close()
+		return stdoutChan, stderrChan, doneChan, errChan, err
+	}
+	stderr, err := session.StderrPipe()
+	if err != nil {
+		session.Close()
+		client.Close()
+		return stdoutChan, stderrChan, doneChan, errChan, err
+	}
+	if err := session.Start(command); err != nil {
+		session.Close()
+		client.Close()
+		return stdoutChan, stderrChan, doneChan, errChan, err
+	}
+
+	go p.wait(client, session, stdout, stderr, stdoutChan, stderrChan, doneChan, errChan)
+
+	return stdoutChan, stderrChan, doneChan, errChan, nil
+}
+
+// wait forwards the output of a started command and reports its end.
+func (p Plugin) wait(
+	client *ssh.Client,
+	session *ssh.Session,
+	stdout, stderr io.Reader,
+	stdoutChan, stderrChan chan string,
+	doneChan chan bool,
+	errChan chan error,
+) {
+	defer close(doneChan)
+	defer close(errChan)
+	defer client.Close()
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.Config.CommandTimeout)
+	defer cancel()
+
+	wg := sync.WaitGroup{}
+	wg.Go(func() { readLines(stdout, stdoutChan) })
+	wg.Go(func() { readLines(stderr, stderrChan) })
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		errChan <- session.Wait()
+		doneChan <- true
+	case <-ctx.Done():
+		errChan <- fmt.Errorf("Run Command Timeout: %v", ctx.Err())
+		doneChan <- false
+	}
+}
+
+// readLines sends every line of r to lines, without the trailing newline,
+// and closes lines at the end of r.
+func readLines(r io.Reader, lines chan<- string) {
+	defer close(lines)
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			lines <- strings.TrimRight(line, "\n")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/appleboy/easyssh-proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestHostKeyCallbackFingerprint(t *testing.T) {
+	key := newHostKey(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+	assert.NoError(t, hostKeyCallback(endpoint{}, nil)("foo.com:22", remote, key))
+	assert.NoError(t, hostKeyCallback(endpoint{Fingerprint: ssh.FingerprintSHA256(key)}, nil)("foo.com:22", remote, key))
+
+	err := hostKeyCallback(endpoint{Fingerprint: "SHA256:other"}, nil)("foo.com:22", remote, key)
+	assert.ErrorContains(t, err, "mismatch for foo.com:22")
+	assert.ErrorContains(t, err, "expected SHA256:other")
+	assert.ErrorContains(t, err, ssh.FingerprintSHA256(key))
+}
+
+// startAgent serves an ssh-agent holding the given keys on SSH_AUTH_SOCK.
+func startAgent(t *testing.T, keys ...any) {
+	t.Helper()
+
+	keyring := agent.NewKeyring()
+	for _, key := range keys {
+		require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: key}))
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_ = agent.ServeAgent(keyring, conn)
+			}()
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", socket)
+}
+
+func TestAgentCredentials(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	plugin := Plugin{
+		Config: Config{
+			Host:     []string{"127.0.0.1:1"},
+			Protocol: easyssh.PROTOCOL_TCP,
+			UseAgent: true,
+			Script:   []string{"whoami"},
+		},
+		Writer: io.Discard,
+	}
+
+	_, err := plugin.Execute()
+	assert.Equal(t, errMissingAgent, err)
+
+	startAgent(t)
+
+	result, err := plugin.Execute()
+	require.Error(t, err)
+	assert.Equal(t, StatusUnreachable, result.Hosts[0].Status)
+
+	plugin.Config.UseAgent = false
+	result, err = plugin.Execute()
+	require.Error(t, err)
+	assert.Equal(t, StatusUnreachable, result.Hosts[0].Status)
+}
+
+func TestAgentUnreachable(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", filepath.Join(t.TempDir(), "missing.sock"))
+
+	client, closer, err := Plugin{}.agent()
+	assert.NoError(t, err)
+	assert.Nil(t, client)
+	assert.Nil(t, closer)
+
+	_, _, err = Plugin{Config: Config{UseAgent: true}}.agent()
+	assert.ErrorContains(t, err, "can't connect to the ssh-agent")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxExpandedHosts bounds the hosts a single entry of Config.Host expands
+// to, a typo such as web[1-100000] fails instead of dialing for hours.
+const maxExpandedHosts = 10000
+
+// hostRange matches a numeric or letter range at the start of a string,
+// IPv6 literals such as [2001:db8::1] never match.
+var hostRange = regexp.MustCompile(`^\[(?:(\d+)-(\d+)|([a-z])-([a-z])|([A-Z])-([A-Z]))\]`)
+
+// expandHosts expands the ranges and braces of the entries of Config.Host,
+// web[01-03] and web{a,b} name three and two hosts. Entries starting with
+// ! drop the hosts they match, * matches any characters. Duplicates keep
+// their first position.
+func expandHosts(entries []string) ([]string, error) {
+	var hosts, exclusions []string
+	for _, entry := range joinBraces(entries) {
+		pattern, exclude := strings.CutPrefix(entry, "!")
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			return nil, fmt.Errorf("error: invalid host %q: missing pattern after !", entry)
+		}
+
+		expanded, err := expandHostPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error: invalid host %q: %w", entry, err)
+		}
+		if exclude {
+			exclusions = append(exclusions, expanded...)
+			continue
+		}
+		for _, host := range expanded {
+			if !slices.Contains(hosts, host) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+
+	return slices.DeleteFunc(hosts, func(host string) bool {
+		return slices.ContainsFunc(exclusions, func(pattern string) bool {
+			return matchesHost(pattern, host)
+		})
+	}), nil
+}
+
+// joinBraces puts back together the entries split at the commas of a
+// brace, the command line splits PLUGIN_HOST=db{a,b} into db{a and b}.
+func joinBraces(entries []string) []string {
+	var joined []string
+	depth := 0
+	for _, entry := range entries {
+		if depth > 0 {
+			joined[len(joined)-1] += "," + entry
+		} else {
+			joined = append(joined, entry)
+		}
+		depth += strings.Count(entry, "{") - strings.Count(entry, "}")
+	}
+	return joined
+}
+
+// expandHostPattern returns the hosts named by pattern in order, the
+// first range or brace varies slowest.
+func expandHostPattern(pattern string) ([]string, error) {
+	start, end, alternatives, err := firstHostGroup(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+
+	var hosts []string
+	for _, alternative := range alternatives {
+		expanded, err := expandHostPattern(pattern[:start] + alternative + pattern[end:])
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, expanded...)
+		if len(hosts) > maxExpandedHosts {
+			return nil, fmt.Errorf("expands to more than %d hosts", maxExpandedHosts)
+		}
+	}
+	return hosts, nil
+}
+
+// firstHostGroup finds the first range or brace of pattern, start is -1
+// when there is none.
+func firstHostGroup(pattern string) (int, int, []string, error) {
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '[':
+			match := hostRange.FindStringSubmatch(pattern[i:])
+			if match == nil {
+				continue
+			}
+			alternatives, err := expandRange(match)
+			return i, i + len(match[0]), alternatives, err
+		case '{':
+			end, alternatives, err := splitBrace(pattern, i)
+			return i, end, alternatives, err
+		case '}':
+			return 0, 0, nil, errors.New("unexpected } without {")
+		}
+	}
+	return -1, -1, nil, nil
+}
+
+// expandRange returns the values of a hostRange match, numbers keep the
+// zero padding of the start as in [01-40].
+func expandRange(match []string) ([]string, error) {
+	if match[1] == "" {
+		from, to := match[3]+match[5], match[4]+match[6]
+		if from > to {
+			return nil, fmt.Errorf("range %s ends before it starts", match[0])
+		}
+		var values []string
+		for c := from[0]; c <= to[0]; c++ {
+			values = append(values, string(c))
+		}
+		return values, nil
+	}
+
+	from, errFrom := strconv.Atoi(match[1])
+	to, errTo := strconv.Atoi(match[2])
+	if errFrom != nil || errTo != nil {
+		return nil, fmt.Errorf("range %s is too large", match[0])
+	}
+	if from > to {
+		return nil, fmt.Errorf("range %s ends before it starts", match[0])
+	}
+	if to-from >= maxExpandedHosts {
+		return nil, fmt.Errorf("expands to more than %d hosts", maxExpandedHosts)
+	}
+
+	width := 0
+	if len(match[1]) > 1 && match[1][0] == '0' {
+		width = len(match[1])
+	}
+	values := make([]string, 0, to-from+1)
+	for n := from; n <= to; n++ {
+		values = append(values, fmt.Sprintf("%0*d", width, n))
+	}
+	return values, nil
+}
+
+// splitBrace returns the end of the brace opened at start and its
+// comma-separated alternatives, nested braces stay in their alternative.
+func splitBrace(pattern string, start int) (int, []string, error) {
+	var alternatives []string
+	depth, from := 0, start+1
+	for i := start; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				alternatives = append(alternatives, pattern[from:i])
+				if len(alternatives) < 2 {
+					return 0, nil, fmt.Errorf(
+						"braces %s need two or more alternatives separated by commas",
+						pattern[start:i+1],
+					)
+				}
+				return i + 1, alternatives, nil
+			}
+		case ',':
+			if depth == 1 {
+				alternatives = append(alternatives, pattern[from:i])
+				from = i + 1
+			}
+		}
+	}
+	return 0, nil, errors.New("missing } after {")
+}
+
+// matchesHost reports whether an exclusion matches the entry or its host
+// without the user.
+func matchesHost(pattern, entry string) bool {
+	glob := escapeHostGlob(pattern)
+	if ok, _ := path.Match(glob, entry); ok {
+		return true
+	}
+	if i := strings.LastIndex(entry, "@"); i >= 0 {
+		ok, _ := path.Match(glob, entry[i+1:])
+		return ok
+	}
+	return false
+}
+
+// escapeHostGlob leaves * as the only wildcard of an exclusion, brackets
+// are IPv6 literals after the expansion.
+func escapeHostGlob(pattern string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`, "?", `\?`).Replace(pattern)
+}
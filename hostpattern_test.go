@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []string
+		want    []string
+	}{
+		{
+			name:    "padded range",
+			entries: []string{"web[08-11].prod.example.com"},
+			want: []string{
+				"web08.prod.example.com",
+				"web09.prod.example.com",
+				"web10.prod.example.com",
+				"web11.prod.example.com",
+			},
+		},
+		{
+			name:    "range without padding",
+			entries: []string{"web[9-10]"},
+			want:    []string{"web9", "web10"},
+		},
+		{
+			name:    "letter range",
+			entries: []string{"rack[a-c]"},
+			want:    []string{"racka", "rackb", "rackc"},
+		},
+		{
+			name:    "braces",
+			entries: []string{"db{a,b,c}.example.com"},
+			want:    []string{"dba.example.com", "dbb.example.com", "dbc.example.com"},
+		},
+		{
+			name:    "nested braces and range",
+			entries: []string{"{web,api{1,2}}-[1-2]"},
+			want:    []string{"web-1", "web-2", "api1-1", "api1-2", "api2-1", "api2-2"},
+		},
+		{
+			name:    "braces split by the command line",
+			entries: []string{"db{a", "b}", "web1"},
+			want:    []string{"dba", "dbb", "web1"},
+		},
+		{
+			name:    "user, port and ipv6 stay",
+			entries: []string{"deploy@web[1-2]:2222", "[2001:db8::1]:22", "[::1]"},
+			want:    []string{"deploy@web1:2222", "deploy@web2:2222", "[2001:db8::1]:22", "[::1]"},
+		},
+		{
+			name:    "duplicates",
+			entries: []string{"web2", "web[1-3]", "web1"},
+			want:    []string{"web2", "web1", "web3"},
+		},
+		{
+			name:    "exclusions",
+			entries: []string{"!web[02-03]", "web[01-05]", "deploy@api1", "!api*", "!web05"},
+			want:    []string{"web01", "web04"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, err := expandHosts(tt.entries)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, hosts)
+		})
+	}
+}
+
+func TestExpandHostsErrors(t *testing.T) {
+	tests := []struct {
+		entry, message string
+	}{
+		{"web[10-01]", "range [10-01] ends before it starts"},
+		{"rack[c-a]", "range [c-a] ends before it starts"},
+		{"web[1-20000]", "expands to more than 10000 hosts"},
+		{"a[1-200]b[1-200]", "expands to more than 10000 hosts"},
+		{"db{a}", "braces {a} need two or more alternatives separated by commas"},
+		{"db}a", "unexpected } without {"},
+		{"!", "missing pattern after !"},
+	}
+	for _, tt := range tests {
+		_, err := expandHosts([]string{tt.entry})
+		assert.ErrorContains(t, err, tt.message, tt.entry)
+		assert.ErrorContains(t, err, "invalid host", tt.entry)
+	}
+
+	_, err := expandHosts([]string{"db{a", "b"})
+	assert.ErrorContains(t, err, "missing } after {")
+}
+
+func TestExpandHostsDebug(t *testing.T) {
+	var buffer bytes.Buffer
+	p := Plugin{
+		Config: Config{
+			Host:     []string{"web[1-2]", "!web2", "!web1"},
+			Password: "1234",
+			Debug:    true,
+		},
+		Writer: &buffer,
+	}
+
+	_, err := p.Execute()
+	assert.ErrorIs(t, err, errMissingHost)
+	assert.Equal(t, "======HOSTS======\n\n======END======\n", buffer.String())
+
+	buffer.Reset()
+	p.Config.Host = []string{"web[1-2]", "!web2", "web3:99999"}
+	_, err = p.Execute()
+	assert.ErrorContains(t, err, `invalid host "web3:99999"`)
+	assert.Equal(t, "======HOSTS======\nweb1\nweb3:99999\n======END======\n", buffer.String())
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxHostsCommandStderr bounds the stderr of hosts_command kept for the
+// error message.
+const maxHostsCommandStderr = 4096
+
+// hostSpec is an entry of Config.Host or Config.HostList, the settings it
+// leaves empty come from the plugin settings.
+type hostSpec struct {
+	// Name is the entry as written, it names the host in the results.
+	Name string
+	User string
+	Host string
+	Port string
+
+	Password    string
+	Key         string
+	KeyPath     string
+	Fingerprint string
+	// Proxy is a proxy_jump value for this host, "none" connects directly.
+	Proxy *string
+	Envs  map[string]string
+}
+
+// hostListEntry is an entry of the structured host list.
+type hostListEntry struct {
+	Host        string   `yaml:"host"`
+	User        string   `yaml:"user"`
+	Username    string   `yaml:"username"`
+	Port        string   `yaml:"port"`
+	Password    string   `yaml:"password"`
+	Key         string   `yaml:"key"`
+	KeyPath     string   `yaml:"key_path"`
+	Fingerprint string   `yaml:"fingerprint"`
+	Proxy       *string  `yaml:"proxy"`
+	Envs        hostEnvs `yaml:"envs"`
+}
+
+// envName matches the variable names that can be exported to the script.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// hostEnvs are the variables exported on a single host, either a list of
+// names read from the environment, as envs, or a map of names to values.
+type hostEnvs map[string]string
+
+func (e *hostEnvs) UnmarshalYAML(node *yaml.Node) error {
+	envs := hostEnvs{}
+	switch node.Kind {
+	case yaml.SequenceNode:
+		var names []string
+		if err := node.Decode(&names); err != nil {
+			return err
+		}
+		for _, name := range names {
+			name = strings.ToUpper(strings.TrimSpace(name))
+			if value, found := os.LookupEnv(name); found {
+				envs[name] = value
+			} else if !envName.MatchString(name) {
+				// kept for hostSpecs to reject with the host
+				envs[name] = ""
+			}
+		}
+	case yaml.MappingNode:
+		var values map[string]string
+		if err := node.Decode(&values); err != nil {
+			return err
+		}
+		for name, value := range values {
+			envs[strings.ToUpper(strings.TrimSpace(name))] = value
+		}
+	default:
+		return fmt.Errorf("line %d: envs must be a list of names or a map of values", node.Line)
+	}
+
+	*e = envs
+	return nil
+}
+
+// splitHost splits a [user@]host[:port] entry of Config.Host, a missing
+// user is empty and a missing port is Config.Port. IPv6 addresses with a
+// port are written [2001:db8::1]:22.
+func (p Plugin) splitHost(entry string) (string, string, string, error) {
+	user, host, port, err := splitUserHostPort(entry)
+	if err != nil {
+		return "", "", "", fmt.Errorf("error: invalid host %q: %w", entry, err)
+	}
+	if port == "" {
+		port = strconv.Itoa(p.Config.Port)
+	}
+	return user, host, port, nil
+}
+
+// splitUserHostPort splits a [user@]host[:port] entry at its last @, a
+// missing user or port is empty. Jump hosts are parsed with it as well.
+func splitUserHostPort(entry string) (string, string, string, error) {
+	user, hostPort := "", entry
+	if i := strings.LastIndex(entry, "@"); i >= 0 {
+		user, hostPort = entry[:i], entry[i+1:]
+		if user == "" {
+			return "", "", "", errors.New("missing user before @")
+		}
+	}
+
+	host, port, err := parseHostPort(hostPort)
+	if err != nil {
+		return "", "", "", err
+	}
+	return user, host, port, nil
+}
+
+// hostSpecs returns the entries of Config.Host followed by the entries of
+// Config.HostList, a JSON or YAML list of hosts with their own settings,
+// and the hosts of Config.Inventory.
+func (p Plugin) hostSpecs() ([]hostSpec, error) {
+	specs := make([]hostSpec, 0, len(p.Config.Host))
+	for _, entry := range p.Config.Host {
+		user, host, port, err := p.splitHost(entry)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, hostSpec{Name: entry, User: user, Host: host, Port: port})
+	}
+
+	if strings.TrimSpace(p.Config.HostList) == "" {
+		return p.appendInventory(specs)
+	}
+
+	var entries []hostListEntry
+	if err := yaml.Unmarshal([]byte(p.Config.HostList), &entries); err != nil {
+		return nil, fmt.Errorf("error: can't parse hosts: %w", err)
+	}
+
+	for i, entry := range entries {
+		name := strings.TrimSpace(entry.Host)
+		if name == "" {
+			return nil, fmt.Errorf("error: hosts entry %d has no host", i+1)
+		}
+
+		user, host, port, err := p.splitHost(name)
+		if err != nil {
+			return nil, err
+		}
+		spec := hostSpec{
+			Name:        name,
+			User:        user,
+			Host:        host,
+			Port:        port,
+			Password:    entry.Password,
+			Key:         entry.Key,
+			KeyPath:     expandHome(strings.TrimSpace(entry.KeyPath)),
+			Fingerprint: strings.TrimSpace(entry.Fingerprint),
+			Proxy:       entry.Proxy,
+			Envs:        entry.Envs,
+		}
+		for env := range entry.Envs {
+			if !envName.MatchString(env) {
+				return nil, fmt.Errorf(
+					"error: invalid envs name %q of host %q, use letters, digits and _",
+					env,
+					name,
+				)
+			}
+		}
+		if entry.User != "" {
+			spec.User = entry.User
+		}
+		if entry.Username != "" {
+			spec.User = entry.Username
+		}
+		if port := strings.TrimSpace(entry.Port); port != "" {
+			if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+				return nil, fmt.Errorf(
+					"error: invalid port %q of host %q, use a number between 1 and 65535",
+					port,
+					name,
+				)
+			}
+			spec.Port = port
+		}
+
+		specs = append(specs, spec)
+	}
+
+	return p.appendInventory(specs)
+}
+
+// appendInventory appends the hosts of Config.Inventory missing from specs.
+func (p Plugin) appendInventory(specs []hostSpec) ([]hostSpec, error) {
+	inventory, err := p.inventorySpecs()
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range inventory {
+		if !slices.ContainsFunc(specs, func(s hostSpec) bool { return s.Name == spec.Name }) {
+			specs = append(specs, spec)
+		}
+	}
+	return specs, nil
+}
+
+// hasCredentials reports whether t has something to authenticate with.
+func (t target) hasCredentials() bool {
+	return t.Password != "" || t.Key != "" || t.KeyPath != "" ||
+		len(t.Keys) > 0 || len(t.KeyPaths) > 0 || len(t.Answers) > 0 ||
+		os.Getenv("SSH_AUTH_SOCK") != ""
+}
+
+// listedHosts returns the hosts of Config.HostsFile and the output of
+// Config.HostsCommand: one or more hosts per line, # starts a comment.
+func (p Plugin) listedHosts() ([]string, error) {
+	var hosts []string
+	if path := strings.TrimSpace(p.Config.HostsFile); path != "" {
+		data, err := os.ReadFile(expandHome(path))
+		if err != nil {
+			return nil, fmt.Errorf("error: can't read hosts_file: %w", err)
+		}
+		hosts = append(hosts, parseHostLines(string(data))...)
+	}
+
+	if command := strings.TrimSpace(p.Config.HostsCommand); command != "" {
+		output, err := p.runHostsCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, parseHostLines(output)...)
+	}
+
+	return hosts, nil
+}
+
+// parseHostLines splits data at line breaks and spaces, the tab-separated
+// output of a cloud CLI lists several hosts on a line.
+func parseHostLines(data string) []string {
+	var hosts []string
+	for line := range strings.Lines(data) {
+		line, _, _ = strings.Cut(line, "#")
+		hosts = append(hosts, strings.Fields(line)...)
+	}
+	return hosts
+}
+
+// runHostsCommand runs command with sh and returns its stdout, it is
+// killed after Config.HostsCommandTimeout.
+func (p Plugin) runHostsCommand(command string) (string, error) {
+	ctx := context.Background()
+	if p.Config.HostsCommandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Config.HostsCommandTimeout)
+		defer cancel()
+	}
+
+	var stdout bytes.Buffer
+	stderr := cappedBuffer{limit: maxHostsCommandStderr}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = fmt.Errorf("timed out after %s", p.Config.HostsCommandTimeout)
+		}
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("error: hosts_command %q failed: %w: %s", command, err, message)
+		}
+		return "", fmt.Errorf("error: hosts_command %q failed: %w", command, err)
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	easyssh "github.com/appleboy/easyssh-proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitHost(t *testing.T) {
+	p := Plugin{Config: Config{Port: 22, Protocol: easyssh.PROTOCOL_TCP}}
+
+	tests := []struct {
+		entry, user, host, port string
+	}{
+		{"web1", "", "web1", "22"},
+		{"localhost:443", "", "localhost", "443"},
+		{"::1", "", "::1", "22"},
+		{"[::1]", "", "::1", "22"},
+		{"web1:2222", "", "web1", "2222"},
+		{"ubuntu@web1", "ubuntu", "web1", "22"},
+		{"ec2-user@10.0.0.5:2200", "ec2-user", "10.0.0.5", "2200"},
+		{"me@corp@web1", "me@corp", "web1", "22"},
+		{"2001:db8::1", "", "2001:db8::1", "22"},
+		{"[2001:db8::1]:2222", "", "2001:db8::1", "2222"},
+		{"ubuntu@[fe80::1%eth0]:2200", "ubuntu", "fe80::1%eth0", "2200"},
+		{"[bastion.example.com]:2200", "", "bastion.example.com", "2200"},
+	}
+	for _, tt := range tests {
+		user, host, port, err := p.splitHost(tt.entry)
+		require.NoError(t, err, tt.entry)
+		assert.Equal(t, []string{tt.user, tt.host, tt.port}, []string{user, host, port}, tt.entry)
+	}
+}
+
+func TestSplitHostErrors(t *testing.T) {
+	p := Plugin{Config: Config{Port: 22}}
+
+	tests := []struct {
+		entry, message string
+	}{
+		{"[2001:db8::1:2222", "missing ] after the IPv6 address"},
+		{"[2001:db8::1]2222", `unexpected "2222" after ]`},
+		{"[2001:db8::1]:", "missing port after ]:"},
+		{"[2001:db8::zz]:22", `"2001:db8::zz" is not an IPv6 address`},
+		{"2001:db8::zz:2222", "too many colons, write IPv6 addresses with a port as [2001:db8::1]:22"},
+		{"web1:", "missing port after :"},
+		{"web1:ssh", `port "ssh" is not a number between 1 and 65535`},
+		{"web1:70000", `port "70000" is not a number between 1 and 65535`},
+		{":22", "missing host"},
+		{"[]:22", "missing host"},
+		{"@web1", "missing user before @"},
+		{"ubuntu@", "missing host"},
+	}
+	for _, tt := range tests {
+		_, _, _, err := p.splitHost(tt.entry)
+		assert.EqualError(t, err, "error: invalid host "+strconv.Quote(tt.entry)+": "+tt.message, tt.entry)
+	}
+}
+
+func TestInvalidHostFailsBeforeDial(t *testing.T) {
+	p := Plugin{Config: Config{
+		Host:     []string{"web1", "[2001:db8::1]:22", "2001:db8::1:2222x"},
+		Port:     22,
+		Password: "1234",
+	}}
+
+	_, err := p.Execute()
+	assert.ErrorContains(t, err, `invalid host "2001:db8::1:2222x"`)
+
+	p.Config.Host = []string{"web1"}
+	p.Config.HostList = `[{"host": "[::1]", "port": 0}]`
+	_, err = p.Execute()
+	assert.ErrorContains(t, err, `invalid port "0" of host "[::1]"`)
+}
+
+func TestHostSpecs(t *testing.T) {
+	t.Setenv("RELEASE", "v1.2.3")
+
+	p := Plugin{Config: Config{
+		Host:     []string{"ubuntu@web1:2222"},
+		Port:     22,
+		Protocol: easyssh.PROTOCOL_TCP,
+		HostList: `
+- host: rhel1
+  username: ec2-user
+  port: 2200
+  key_path: /keys/rhel
+  fingerprint: SHA256:rhel1
+  envs:
+    role: primary
+- host: admin@db:2022
+  password: secret
+  proxy: none
+  envs: [release, missing]
+`,
+	}}
+
+	specs, err := p.hostSpecs()
+	require.NoError(t, err)
+	require.Len(t, specs, 3)
+
+	assert.Equal(t, hostSpec{Name: "ubuntu@web1:2222", User: "ubuntu", Host: "web1", Port: "2222"}, specs[0])
+
+	assert.Equal(t, "rhel1", specs[1].Name)
+	assert.Equal(t, "ec2-user", specs[1].User)
+	assert.Equal(t, "2200", specs[1].Port)
+	assert.Equal(t, "/keys/rhel", specs[1].KeyPath)
+	assert.Equal(t, "SHA256:rhel1", specs[1].Fingerprint)
+	assert.Equal(t, map[string]string{"ROLE": "primary"}, specs[1].Envs)
+	assert.Nil(t, specs[1].Proxy)
+
+	assert.Equal(t, "admin", specs[2].User)
+	assert.Equal(t, "db", specs[2].Host)
+	assert.Equal(t, "2022", specs[2].Port)
+	assert.Equal(t, "secret", specs[2].Password)
+	require.NotNil(t, specs[2].Proxy)
+	assert.Equal(t, "none", *specs[2].Proxy)
+	assert.Equal(t, map[string]string{"RELEASE": "v1.2.3"}, specs[2].Envs)
+
+	p.Config.HostList = `[{"host": "web2", "port": 2201, "user": "deploy"}]`
+	specs, err = p.hostSpecs()
+	require.NoError(t, err)
+	assert.Equal(t, "2201", specs[1].Port)
+	assert.Equal(t, "deploy", specs[1].User)
+
+	p.Config.HostList = `[{"port": 22}]`
+	_, err = p.hostSpecs()
+	assert.ErrorContains(t, err, "hosts entry 1 has no host")
+
+	p.Config.HostList = `[{"host": "web2", "envs": "ROLE"}]`
+	_, err = p.hostSpecs()
+	assert.ErrorContains(t, err, "envs must be a list of names or a map of values")
+
+	for _, envs := range []string{`{"my var": "x"}`, `{"a;b": "x"}`, `["1st"]`} {
+		p.Config.HostList = `[{"host": "web2", "envs": ` + envs + `}]`
+		_, err = p.hostSpecs()
+		assert.ErrorContains(t, err, `of host "web2", use letters, digits and _`, envs)
+	}
+
+	p.Config.HostList = `host: web2`
+	_, err = p.hostSpecs()
+	assert.ErrorContains(t, err, "can't parse hosts")
+}
+
+func TestTargetsHostList(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	p := Plugin{Config: Config{
+		Host:        []string{"ubuntu@web1"},
+		Username:    "root",
+		Port:        22,
+		Protocol:    easyssh.PROTOCOL_TCP,
+		KeyPath:     "./tests/.ssh/id_rsa",
+		Fingerprint: "SHA256:default",
+		Proxy: easyssh.DefaultConfig{
+			Server: "bastion",
+			Port:   "22",
+			User:   "jump",
+		},
+		HostList: `
+- host: rhel1
+  username: ec2-user
+  password: secret
+  fingerprint: SHA256:rhel1
+  proxy: b1,admin@b2:2222
+- host: db
+  proxy: none
+`,
+	}}
+
+	targets, err := p.targets()
+	require.NoError(t, err)
+	require.Len(t, targets, 3)
+
+	assert.Equal(t, "ubuntu", targets[0].User)
+	assert.Equal(t, "SHA256:default", targets[0].Fingerprint)
+	assert.Equal(t, "bastion:22", targets[0].hops()[0].address())
+
+	assert.Equal(t, "ec2-user", targets[1].User)
+	assert.Equal(t, "secret", targets[1].Password)
+	assert.Equal(t, "./tests/.ssh/id_rsa", targets[1].KeyPath)
+	assert.Equal(t, "SHA256:rhel1", targets[1].Fingerprint)
+	hops := targets[1].hops()
+	require.Len(t, hops, 2)
+	assert.Equal(t, "jump@b1:22", hops[0].User+"@"+hops[0].address())
+	assert.Equal(t, "admin@b2:2222", hops[1].User+"@"+hops[1].address())
+
+	assert.Equal(t, "root", targets[2].User)
+	assert.Empty(t, targets[2].hops())
+
+	p.Config.HostList = `[{"host": "db", "proxy": "b1:ssh"}]`
+	_, err = p.targets()
+	assert.ErrorContains(t, err, "in the proxy of host db")
+}
+
+func TestHostListCredentials(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	p := Plugin{Config: Config{
+		HostList: `
+- host: web1
+  password: secret
+- host: web2
+`,
+	}}
+
+	_, err := p.Execute()
+	assert.ErrorIs(t, err, errMissingPasswordOrKey)
+	assert.ErrorContains(t, err, "for web2")
+
+	p.Config.HostList = "[]"
+	_, err = p.Execute()
+	assert.ErrorIs(t, err, errMissingHost)
+}
+
+func TestListedHosts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	require.NoError(t, os.WriteFile(path, []byte(`# generated by the provision step
+web1
+  web2:2222   # canary
+
+deploy@[2001:db8::1]:22
+`), 0o600))
+
+	p := Plugin{Config: Config{
+		HostsFile:    path,
+		HostsCommand: `printf 'i-1\t10.0.0.1\t10.0.0.2\n# done\n'`,
+	}}
+
+	hosts, err := p.listedHosts()
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"web1",
+		"web2:2222",
+		"deploy@[2001:db8::1]:22",
+		"i-1",
+		"10.0.0.1",
+		"10.0.0.2",
+	}, hosts)
+
+	p.Config.HostsFile = filepath.Join(t.TempDir(), "missing")
+	_, err = p.listedHosts()
+	assert.ErrorContains(t, err, "can't read hosts_file")
+
+	p.Config.HostsFile = ""
+	p.Config.HostsCommand = "echo denied >&2; exit 3"
+	_, err = p.listedHosts()
+	assert.EqualError(t, err, `error: hosts_command "echo denied >&2; exit 3" failed: exit status 3: denied`)
+
+	p.Config.HostsCommand = "sleep 5"
+	p.Config.HostsCommandTimeout = 100 * time.Millisecond
+	_, err = p.listedHosts()
+	assert.ErrorContains(t, err, "timed out after 100ms")
+}
+
+func TestListedHostsExecute(t *testing.T) {
+	var buffer bytes.Buffer
+	p := Plugin{
+		Config: Config{
+			Host:         []string{"web1", "!web3"},
+			HostsCommand: "echo web1 web[2-4]; echo '\tweb5 # spare'",
+			Password:     "1234",
+			Debug:        true,
+			Fingerprints: []string{"web9=SHA256:x"},
+		},
+		Writer: &buffer,
+	}
+
+	// the fingerprint check fails before any dial, after the hosts are read
+	_, err := p.Execute()
+	assert.ErrorContains(t, err, `fingerprint host "web9" is not in the host list`)
+	assert.Equal(t, "======HOSTS======\nweb1\nweb2\nweb4\nweb5\n======END======\n", buffer.String())
+
+	p.Config.Host = nil
+	p.Config.HostsCommand = "true"
+	_, err = p.Execute()
+	assert.ErrorIs(t, err, errMissingHost)
+}
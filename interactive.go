@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// answer replies to the keyboard-interactive prompts matching prompt.
+type answer struct {
+	prompt *regexp.Regexp
+	value  string
+}
+
+// parseAnswers reads the prompt=answer entries of name. The prompt is a
+// regular expression, an answer of the form $NAME or ${NAME} is read from
+// the environment so one-time codes can be passed at run time.
+func parseAnswers(name string, entries []string) ([]answer, error) {
+	var answers []answer
+	for _, entry := range trimValues(entries) {
+		prompt, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("error: %s entry %q is not prompt=answer", name, entry)
+		}
+
+		re, err := regexp.Compile(strings.TrimSpace(prompt))
+		if err != nil {
+			return nil, fmt.Errorf("error: invalid %s prompt %q: %w", name, prompt, err)
+		}
+
+		value = strings.TrimSpace(value)
+		if env, ok := envReference(value); ok {
+			v, found := os.LookupEnv(env)
+			if !found {
+				return nil, fmt.Errorf("error: %s answer of %q reads $%s, which is not set", name, prompt, env)
+			}
+			value = v
+		}
+
+		answers = append(answers, answer{prompt: re, value: value})
+	}
+
+	return answers, nil
+}
+
+// envReference returns NAME when value is $NAME or ${NAME}.
+func envReference(value string) (string, bool) {
+	name, ok := strings.CutPrefix(value, "$")
+	if !ok {
+		return "", false
+	}
+	if braced, ok := strings.CutPrefix(name, "{"); ok {
+		if name, ok = strings.CutSuffix(braced, "}"); !ok {
+			return "", false
+		}
+	}
+
+	valid := name != ""
+	for i, r := range name {
+		if r != '_' && (r < 'A' || r > 'Z') && (r < 'a' || r > 'z') && (i == 0 || r < '0' || r > '9') {
+			valid = false
+		}
+	}
+	return name, valid
+}
+
+// keyboardInteractive answers every question with the first matching
+// answer, the password replies to the other prompts.
+func keyboardInteractive(
+	password string,
+	answers []answer,
+	creds *credentials,
+) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		if creds.hostKeyChanged {
+			return nil, errHostKeyChanged
+		}
+
+		replies := make([]string, len(questions))
+		for i, question := range questions {
+			replies[i] = password
+			matched := false
+			for _, a := range answers {
+				if a.prompt.MatchString(question) {
+					replies[i] = a.value
+					matched = true
+					break
+				}
+			}
+			if !matched && password == "" {
+				return nil, fmt.Errorf("ssh: no answer for the keyboard-interactive prompt %q", question)
+			}
+		}
+
+		if len(questions) > 0 {
+			creds.used = "keyboard-interactive"
+		}
+		return replies, nil
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseAnswers(t *testing.T) {
+	t.Setenv("OTP_CODE", "123456")
+
+	answers, err := parseAnswers("keyboard_interactive", []string{
+		"(?i)verification code=$OTP_CODE",
+		"",
+		"Token:=${OTP_CODE}",
+		"PIN = 0000",
+	})
+	require.NoError(t, err)
+	require.Len(t, answers, 3)
+	assert.True(t, answers[0].prompt.MatchString("Verification code: "))
+	assert.Equal(t, "123456", answers[0].value)
+	assert.Equal(t, "123456", answers[1].value)
+	assert.Equal(t, "0000", answers[2].value)
+
+	_, err = parseAnswers("keyboard_interactive", []string{"Token"})
+	assert.ErrorContains(t, err, "is not prompt=answer")
+
+	_, err = parseAnswers("keyboard_interactive", []string{"(=1"})
+	assert.ErrorContains(t, err, "invalid keyboard_interactive prompt")
+
+	_, err = parseAnswers("proxy_keyboard_interactive", []string{"Token=$MISSING_OTP"})
+	assert.ErrorContains(t, err, "reads $MISSING_OTP, which is not set")
+
+	answers, err = parseAnswers("keyboard_interactive", []string{"Token=$1.50"})
+	require.NoError(t, err)
+	assert.Equal(t, "$1.50", answers[0].value)
+}
+
+func TestKeyboardInteractive(t *testing.T) {
+	answers, err := parseAnswers("keyboard_interactive", []string{"code=654321"})
+	require.NoError(t, err)
+
+	var creds credentials
+	challenge := keyboardInteractive("secret", answers, &creds)
+
+	replies, err := challenge("", "", nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, replies)
+	assert.Empty(t, creds.used)
+
+	replies, err = challenge("", "", []string{"Password: ", "Verification code: "}, []bool{false, false})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"secret", "654321"}, replies)
+	assert.Equal(t, "keyboard-interactive", creds.used)
+
+	challenge = keyboardInteractive("", answers, &creds)
+	_, err = challenge("", "", []string{"Password: "}, []bool{false})
+	assert.ErrorContains(t, err, `no answer for the keyboard-interactive prompt "Password: "`)
+}
+
+func TestKeyboardInteractiveOnlyServer(t *testing.T) {
+	config := &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(
+			_ ssh.ConnMetadata,
+			challenge ssh.KeyboardInteractiveChallenge,
+		) (*ssh.Permissions, error) {
+			replies, err := challenge("", "", []string{"Password: ", "OTP: "}, []bool{false, false})
+			if err != nil {
+				return nil, err
+			}
+			if len(replies) != 2 || replies[0] != "secret" || replies[1] != "123456" {
+				return nil, errors.New("wrong answers")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(newSigner(t))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if sc, chans, reqs, err := ssh.NewServerConn(conn, config); err == nil {
+					go ssh.DiscardRequests(reqs)
+					go func() {
+						for ch := range chans {
+							_ = ch.Reject(ssh.Prohibited, "no sessions")
+						}
+					}()
+					_ = sc.Wait()
+				}
+			}()
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	t.Setenv("OTP_CODE", "123456")
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	answers, err := parseAnswers("keyboard_interactive", []string{"OTP=$OTP_CODE"})
+	require.NoError(t, err)
+	client, err := Plugin{}.dial(target{
+		Host:     "127.0.0.1",
+		Port:     port,
+		User:     "deploy",
+		Password: "secret",
+		Answers:  answers,
+	})
+	require.NoError(t, err)
+	client.Close()
+
+	_, err = Plugin{}.dial(target{Host: "127.0.0.1", Port: port, User: "deploy", Password: "secret"})
+	assert.ErrorContains(t, err, "unable to authenticate")
+}
@@ -0,0 +1,495 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// inventoryRange matches the [01:40] and [a:f] ranges of Ansible host
+// names, expandHostPattern reads them as [01-40] and [a-f].
+var inventoryRange = regexp.MustCompile(`\[(\d+|[a-zA-Z]):(\d+|[a-zA-Z])\]`)
+
+// inventory is an Ansible inventory: hosts with their variables and
+// groups of hosts and child groups with their own variables.
+type inventory struct {
+	path     string
+	hosts    []string
+	hostVars map[string]map[string]string
+	groups   map[string]*inventoryGroup
+}
+
+type inventoryGroup struct {
+	hosts    []string
+	children []string
+	vars     map[string]string
+}
+
+// loadInventory reads an INI inventory, or a YAML one when the file ends
+// in .yml, .yaml or .json.
+func loadInventory(path string) (*inventory, error) {
+	data, err := os.ReadFile(expandHome(path))
+	if err != nil {
+		return nil, fmt.Errorf("error: can't open inventory: %w", err)
+	}
+
+	inv := &inventory{
+		path:     path,
+		hostVars: map[string]map[string]string{},
+		groups:   map[string]*inventoryGroup{},
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml", ".json":
+		err = inv.parseYAML(data)
+	default:
+		err = inv.parseINI(string(data))
+	}
+	if err == nil {
+		err = inv.checkChildren()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error: can't parse inventory %s: %w", path, err)
+	}
+
+	return inv, nil
+}
+
+func (inv *inventory) group(name string) *inventoryGroup {
+	g, ok := inv.groups[name]
+	if !ok {
+		g = &inventoryGroup{vars: map[string]string{}}
+		inv.groups[name] = g
+	}
+	return g
+}
+
+// addHosts adds the hosts of pattern to group, vars are merged into the
+// variables of each host.
+func (inv *inventory) addHosts(group, pattern string, vars map[string]string) error {
+	hosts, err := expandHostPattern(inventoryRange.ReplaceAllString(pattern, "[$1-$2]"))
+	if err != nil {
+		return fmt.Errorf("host %q: %w", pattern, err)
+	}
+
+	g := inv.group(group)
+	for _, host := range hosts {
+		if _, ok := inv.hostVars[host]; !ok {
+			inv.hosts = append(inv.hosts, host)
+			inv.hostVars[host] = map[string]string{}
+		}
+		maps.Copy(inv.hostVars[host], vars)
+		if !slices.Contains(g.hosts, host) {
+			g.hosts = append(g.hosts, host)
+		}
+	}
+	return nil
+}
+
+func (inv *inventory) addChild(group, child string) {
+	inv.group(child)
+	g := inv.group(group)
+	if !slices.Contains(g.children, child) {
+		g.children = append(g.children, child)
+	}
+}
+
+// parseINI reads [group], [group:vars] and [group:children] sections,
+// hosts before the first section are ungrouped.
+func (inv *inventory) parseINI(data string) error {
+	group, kind := "ungrouped", "hosts"
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			group, kind = line[1:len(line)-1], "hosts"
+			if name, suffix, ok := strings.Cut(group, ":"); ok {
+				if suffix != "vars" && suffix != "children" {
+					return fmt.Errorf("line %d: unknown section %s", n, line)
+				}
+				group, kind = name, suffix
+			}
+			inv.group(group)
+			continue
+		}
+
+		switch kind {
+		case "vars":
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return fmt.Errorf("line %d: %q is not key=value", n, line)
+			}
+			inv.group(group).vars[strings.TrimSpace(key)] = unquoteInventory(strings.TrimSpace(value))
+		case "children":
+			inv.addChild(group, line)
+		default:
+			fields, err := splitInventoryLine(line)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", n, err)
+			}
+			vars := map[string]string{}
+			for _, field := range fields[1:] {
+				key, value, ok := strings.Cut(field, "=")
+				if !ok {
+					return fmt.Errorf("line %d: %q is not key=value", n, field)
+				}
+				vars[key] = unquoteInventory(value)
+			}
+			if err := inv.addHosts(group, fields[0], vars); err != nil {
+				return fmt.Errorf("line %d: %w", n, err)
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// splitInventoryLine splits a host line at the spaces outside quotes,
+// the quotes stay for unquoteInventory.
+func splitInventoryLine(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+			field.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			field.WriteRune(r)
+		case r == '#' && field.Len() == 0:
+			// the rest of the line is a comment
+			return fields, nil
+		case r == ' ' || r == '\t':
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteRune(r)
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("missing closing %c", quote)
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+// unquoteInventory strips the quotes around value and the quotes of a
+// key="value with spaces" field.
+func unquoteInventory(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// parseYAML reads the groups at the top of a YAML inventory, each with
+// optional hosts, vars and children.
+func (inv *inventory) parseYAML(data []byte) error {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	if len(root.Content) == 0 {
+		return nil
+	}
+
+	top := root.Content[0]
+	if top.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: the inventory must map group names to groups", top.Line)
+	}
+	for i := 0; i < len(top.Content); i += 2 {
+		if err := inv.addYAMLGroup(top.Content[i].Value, top.Content[i+1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (inv *inventory) addYAMLGroup(name string, node *yaml.Node) error {
+	inv.group(name)
+	if node.Tag == "!!null" {
+		return nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: group %s must be a map of hosts, vars and children", node.Line, name)
+	}
+
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		if value.Tag == "!!null" {
+			continue
+		}
+		switch key {
+		case "hosts":
+			if value.Kind != yaml.MappingNode {
+				return fmt.Errorf("line %d: hosts of group %s must be a map", value.Line, name)
+			}
+			for j := 0; j < len(value.Content); j += 2 {
+				vars, err := decodeInventoryVars(value.Content[j+1])
+				if err != nil {
+					return err
+				}
+				if err := inv.addHosts(name, value.Content[j].Value, vars); err != nil {
+					return fmt.Errorf("line %d: %w", value.Content[j].Line, err)
+				}
+			}
+		case "vars":
+			vars, err := decodeInventoryVars(value)
+			if err != nil {
+				return err
+			}
+			maps.Copy(inv.group(name).vars, vars)
+		case "children":
+			if value.Kind != yaml.MappingNode {
+				return fmt.Errorf("line %d: children of group %s must be a map", value.Line, name)
+			}
+			for j := 0; j < len(value.Content); j += 2 {
+				child := value.Content[j].Value
+				inv.addChild(name, child)
+				if err := inv.addYAMLGroup(child, value.Content[j+1]); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("line %d: unknown key %q in group %s", node.Content[i].Line, key, name)
+		}
+	}
+	return nil
+}
+
+// decodeInventoryVars returns the variables of node as strings, lists and
+// maps as JSON.
+func decodeInventoryVars(node *yaml.Node) (map[string]string, error) {
+	vars := map[string]string{}
+	if node.Tag == "!!null" {
+		return vars, nil
+	}
+
+	var values map[string]any
+	if err := node.Decode(&values); err != nil {
+		return nil, err
+	}
+	for key, value := range values {
+		switch value := value.(type) {
+		case nil:
+			vars[key] = ""
+		case string:
+			vars[key] = value
+		case []any, map[string]any:
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: variable %s: %w", node.Line, key, err)
+			}
+			vars[key] = string(data)
+		default:
+			vars[key] = fmt.Sprint(value)
+		}
+	}
+	return vars, nil
+}
+
+// checkChildren rejects groups that are their own descendants.
+func (inv *inventory) checkChildren() error {
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		if slices.Contains(path, name) {
+			return fmt.Errorf("group %s is a child of itself", name)
+		}
+		for _, child := range inv.groups[name].children {
+			if err := visit(child, append(path, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(inv.groups)) {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// members returns the hosts of group and its child groups in order, every
+// host for all.
+func (inv *inventory) members(group string) []string {
+	if group == "all" {
+		return inv.hosts
+	}
+
+	var hosts []string
+	var visit func(name string)
+	visit = func(name string) {
+		g := inv.groups[name]
+		for _, host := range g.hosts {
+			if !slices.Contains(hosts, host) {
+				hosts = append(hosts, host)
+			}
+		}
+		for _, child := range g.children {
+			visit(child)
+		}
+	}
+	visit(group)
+	return hosts
+}
+
+// depth is the distance of group from the top of the inventory, variables
+// of deeper groups win as in Ansible.
+func (inv *inventory) depth(group string) int {
+	depth := 0
+	for name, g := range inv.groups {
+		if slices.Contains(g.children, group) {
+			depth = max(depth, inv.depth(name)+1)
+		}
+	}
+	return depth
+}
+
+// vars returns the variables of host: those of all, of its groups from
+// the top down and then its own.
+func (inv *inventory) vars(host string) map[string]string {
+	var groups []string
+	for name := range inv.groups {
+		if name != "all" && slices.Contains(inv.members(name), host) {
+			groups = append(groups, name)
+		}
+	}
+	slices.SortFunc(groups, func(a, b string) int {
+		if d := inv.depth(a) - inv.depth(b); d != 0 {
+			return d
+		}
+		return strings.Compare(a, b)
+	})
+
+	vars := map[string]string{}
+	if all, ok := inv.groups["all"]; ok {
+		maps.Copy(vars, all.vars)
+	}
+	for _, name := range groups {
+		maps.Copy(vars, inv.groups[name].vars)
+	}
+	maps.Copy(vars, inv.hostVars[host])
+	return vars
+}
+
+// inventorySpecs returns the hosts of Config.Groups in Config.Inventory,
+// every host when no group is set.
+func (p Plugin) inventorySpecs() ([]hostSpec, error) {
+	if p.Config.Inventory == "" {
+		if len(trimValues(p.Config.Groups)) > 0 {
+			return nil, errors.New("error: group needs an inventory")
+		}
+		return nil, nil
+	}
+
+	inv, err := loadInventory(p.Config.Inventory)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := trimValues(p.Config.Groups)
+	if len(groups) == 0 {
+		groups = []string{"all"}
+	}
+
+	var names []string
+	for _, group := range groups {
+		if _, ok := inv.groups[group]; !ok && group != "all" {
+			return nil, fmt.Errorf("error: group %q is not in the inventory %s", group, inv.path)
+		}
+		for _, host := range inv.members(group) {
+			if !slices.Contains(names, host) {
+				names = append(names, host)
+			}
+		}
+	}
+
+	specs := make([]hostSpec, 0, len(names))
+	for _, name := range names {
+		spec, err := p.inventorySpec(name, inv.vars(name))
+		if err != nil {
+			return nil, fmt.Errorf("%w, in the inventory %s", err, inv.path)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// inventorySpec maps the connection variables of an inventory host to its
+// settings, the other variables are exported to the script. Variables of
+// Ansible itself, such as ansible_become, are left out.
+func (p Plugin) inventorySpec(name string, vars map[string]string) (hostSpec, error) {
+	user, host, port, err := p.splitHost(name)
+	if err != nil {
+		return hostSpec{}, err
+	}
+
+	spec := hostSpec{Name: name, User: user, Host: host, Port: port}
+	for key, value := range vars {
+		value = strings.TrimSpace(value)
+		switch key {
+		case "ansible_host":
+			host, port, err := parseHostPort(value)
+			if err == nil && port != "" {
+				err = errors.New("set the port with ansible_port")
+			}
+			if err != nil {
+				return hostSpec{}, fmt.Errorf("error: invalid ansible_host %q of host %q: %w", value, name, err)
+			}
+			spec.Host = host
+		case "ansible_port":
+			if n, err := strconv.Atoi(value); err != nil || n < 1 || n > 65535 {
+				return hostSpec{}, fmt.Errorf(
+					"error: invalid ansible_port %q of host %q, use a number between 1 and 65535",
+					value,
+					name,
+				)
+			}
+			spec.Port = value
+		case "ansible_user":
+			spec.User = value
+		case "ansible_ssh_private_key_file":
+			spec.KeyPath = expandHome(value)
+		default:
+			if strings.HasPrefix(key, "ansible_") {
+				continue
+			}
+			if !envName.MatchString(key) {
+				return hostSpec{}, fmt.Errorf(
+					"error: invalid variable name %q of host %q, use letters, digits and _",
+					key,
+					name,
+				)
+			}
+			if spec.Envs == nil {
+				spec.Envs = map[string]string{}
+			}
+			spec.Envs[strings.ToUpper(key)] = vars[key]
+		}
+	}
+	return spec, nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	easyssh "github.com/appleboy/easyssh-proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeInventory(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+const iniInventory = `
+# deploy targets
+bastion ansible_host=203.0.113.1
+
+[web]
+web[01:03].example.com role=frontend
+web04.example.com:2222 ansible_user=ubuntu motd="hello world"
+
+[db]
+db1 ansible_host=10.0.0.5 ansible_port=2200 ansible_ssh_private_key_file=/keys/db ansible_become=true
+
+[web:vars]
+tier = web
+
+[prod:children]
+web
+db
+
+[prod:vars]
+tier=prod
+region=eu
+
+[all:vars]
+region=us
+owner='ops team'
+`
+
+func TestInventoryINI(t *testing.T) {
+	p := Plugin{Config: Config{
+		Port:      22,
+		Protocol:  easyssh.PROTOCOL_TCP,
+		Inventory: writeInventory(t, "hosts", iniInventory),
+	}}
+
+	specs, err := p.inventorySpecs()
+	require.NoError(t, err)
+
+	var names []string
+	for _, spec := range specs {
+		names = append(names, spec.Name)
+	}
+	assert.Equal(t, []string{
+		"bastion",
+		"web01.example.com",
+		"web02.example.com",
+		"web03.example.com",
+		"web04.example.com:2222",
+		"db1",
+	}, names)
+
+	assert.Equal(t, hostSpec{
+		Name: "bastion",
+		Host: "203.0.113.1",
+		Port: "22",
+		Envs: map[string]string{"REGION": "us", "OWNER": "ops team"},
+	}, specs[0])
+
+	// web is deeper than prod, its tier wins
+	assert.Equal(t, map[string]string{
+		"ROLE":   "frontend",
+		"TIER":   "web",
+		"REGION": "eu",
+		"OWNER":  "ops team",
+	}, specs[1].Envs)
+
+	assert.Equal(t, "ubuntu", specs[4].User)
+	assert.Equal(t, "web04.example.com", specs[4].Host)
+	assert.Equal(t, "2222", specs[4].Port)
+	assert.Equal(t, "hello world", specs[4].Envs["MOTD"])
+
+	assert.Equal(t, "10.0.0.5", specs[5].Host)
+	assert.Equal(t, "2200", specs[5].Port)
+	assert.Equal(t, "/keys/db", specs[5].KeyPath)
+	assert.NotContains(t, specs[5].Envs, "ANSIBLE_BECOME")
+
+	p.Config.Groups = []string{"db", "web"}
+	specs, err = p.inventorySpecs()
+	require.NoError(t, err)
+	require.Len(t, specs, 5)
+	assert.Equal(t, "db1", specs[0].Name)
+	assert.Equal(t, "web01.example.com", specs[1].Name)
+
+	p.Config.Groups = []string{"prod"}
+	specs, err = p.inventorySpecs()
+	require.NoError(t, err)
+	assert.Len(t, specs, 5)
+
+	p.Config.Groups = []string{"ungrouped"}
+	specs, err = p.inventorySpecs()
+	require.NoError(t, err)
+	require.Len(t, specs, 1)
+	assert.Equal(t, "bastion", specs[0].Name)
+}
+
+func TestInventoryYAML(t *testing.T) {
+	p := Plugin{Config: Config{
+		Port:     22,
+		Protocol: easyssh.PROTOCOL_TCP,
+		Inventory: writeInventory(t, "hosts.yml", `
+all:
+  vars:
+    region: us
+  hosts:
+    bastion:
+      ansible_host: 203.0.113.1
+  children:
+    web:
+      hosts:
+        web[1:2]:
+          ansible_port: 2222
+          replicas: 3
+          labels: [a, b]
+      vars:
+        ansible_user: deploy
+    db:
+      hosts:
+        db1:
+`),
+		Groups: []string{"web", "db"},
+	}}
+
+	specs, err := p.inventorySpecs()
+	require.NoError(t, err)
+	require.Len(t, specs, 3)
+
+	assert.Equal(t, hostSpec{
+		Name: "web1",
+		User: "deploy",
+		Host: "web1",
+		Port: "2222",
+		Envs: map[string]string{"REGION": "us", "REPLICAS": "3", "LABELS": `["a","b"]`},
+	}, specs[0])
+	assert.Equal(t, "web2", specs[1].Name)
+	assert.Equal(t, "db1", specs[2].Name)
+	assert.Equal(t, "22", specs[2].Port)
+	assert.Empty(t, specs[2].User)
+}
+
+func TestInventoryErrors(t *testing.T) {
+	p := Plugin{Config: Config{Port: 22}}
+
+	tests := []struct {
+		name, file, content, message string
+	}{
+		{"unknown section", "hosts", "[web:hosts]\nweb1", "line 1: unknown section [web:hosts]"},
+		{"host variable", "hosts", "web1 role", `line 1: "role" is not key=value`},
+		{"group variable", "hosts", "[web:vars]\nrole", `line 2: "role" is not key=value`},
+		{"quote", "hosts", `web1 motd="hi`, "line 1: missing closing \""},
+		{"range", "hosts", "web[3:1]", "range [3-1] ends before it starts"},
+		{"cycle", "hosts", "[a:children]\nb\n[b:children]\na", "is a child of itself"},
+		{"port", "hosts", "web1 ansible_port=ssh", `invalid ansible_port "ssh" of host "web1"`},
+		{"host", "hosts", "web1 ansible_host=bad:host:22", `invalid ansible_host "bad:host:22" of host "web1": too many colons`},
+		{"host with port", "hosts", "web1 ansible_host=10.0.0.5:22", `invalid ansible_host "10.0.0.5:22" of host "web1": set the port with ansible_port`},
+		{"empty host", "hosts.yml", "all:\n  hosts:\n    web1:\n      ansible_host: ''", `invalid ansible_host "" of host "web1": missing host`},
+		{"variable name", "hosts", "web1 a;b=1", `invalid variable name "a;b" of host "web1"`},
+		{"group variable name", "hosts", "web1\n[all:vars]\nmy var=1", `invalid variable name "my var" of host "web1"`},
+		{"yaml variable name", "hosts.yml", "all:\n  hosts:\n    web1:\n      \"x=$(id)\": 1", `invalid variable name "x=$(id)" of host "web1"`},
+		{"yaml group", "hosts.yaml", "all: [web1]", "group all must be a map of hosts, vars and children"},
+		{"yaml key", "hosts.yaml", "all:\n  host:\n    web1:", `line 2: unknown key "host" in group all`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p.Config.Inventory = writeInventory(t, tt.file, tt.content)
+			_, err := p.inventorySpecs()
+			assert.ErrorContains(t, err, tt.message)
+		})
+	}
+
+	p.Config.Inventory = writeInventory(t, "hosts", "web1 ansible_host=[2001:db8::1]:22")
+	_, err := p.inventorySpecs()
+	assert.ErrorContains(t, err, `of host "web1": set the port with ansible_port, in the inventory `+p.Config.Inventory)
+
+	p.Config.Inventory = writeInventory(t, "hosts", "web1")
+	p.Config.Groups = []string{"db"}
+	_, err = p.inventorySpecs()
+	assert.ErrorContains(t, err, `group "db" is not in the inventory`)
+
+	p.Config.Inventory = ""
+	_, err = p.inventorySpecs()
+	assert.EqualError(t, err, "error: group needs an inventory")
+
+	p.Config.Inventory = filepath.Join(t.TempDir(), "missing")
+	p.Config.Groups = nil
+	_, err = p.inventorySpecs()
+	assert.ErrorContains(t, err, "can't open inventory")
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// privateKey is a private key of the settings, name tells the debug
+// output which one it is.
+type privateKey struct {
+	name string
+	data []byte
+}
+
+// privateKeys returns the private keys of e in the order they are tried:
+// KeyPath, Key, KeyPaths then Keys. A key file that can't be read is
+// logged and left out.
+func (e endpoint) privateKeys() []privateKey {
+	var keys []privateKey
+	for _, path := range append([]string{e.KeyPath}, e.KeyPaths...) {
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Printf("getKeyFile error: %v\n", err)
+			continue
+		}
+		keys = append(keys, privateKey{name: path, data: data})
+	}
+
+	if e.Key != "" {
+		keys = append(keys, privateKey{name: "key", data: []byte(e.Key)})
+	}
+	for i, key := range e.Keys {
+		keys = append(keys, privateKey{name: fmt.Sprintf("keys[%d]", i), data: []byte(key)})
+	}
+
+	return keys
+}
+
+// expandKeyPaths expands ~ and the glob patterns of Config.KeyPaths.
+// Public keys and certificates matched by a pattern are skipped.
+func expandKeyPaths(patterns []string) ([]string, error) {
+	var paths []string
+	for _, pattern := range trimValues(patterns) {
+		pattern = expandHome(pattern)
+		if !strings.ContainsAny(pattern, "*?[") {
+			paths = append(paths, pattern)
+			continue
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error: invalid key_paths pattern %q: %w", pattern, err)
+		}
+		slices.Sort(matches)
+		for _, match := range matches {
+			if strings.HasSuffix(match, ".pub") {
+				continue
+			}
+			if info, err := os.Stat(match); err != nil || info.IsDir() {
+				continue
+			}
+			paths = append(paths, match)
+		}
+	}
+
+	return paths, nil
+}
+
+// signer parses a private key, the passphrase is only used for
+// encrypted keys so encrypted and plain keys can be mixed.
+func signer(key []byte, passphrase string) (ssh.Signer, error) {
+	s, err := ssh.ParsePrivateKey(key)
+	var missing *ssh.PassphraseMissingError
+	if passphrase != "" && errors.As(err, &missing) {
+		return ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	}
+	return s, err
+}
+
+// credentials records how many credentials a client offers and which
+// one the server accepted.
+type credentials struct {
+	offered int
+	used    string
+	// hostKeyChanged refuses the password and keyboard-interactive,
+	// the server key was accepted with strict_host_key_checking no.
+	hostKeyChanged bool
+}
+
+// namedSigner wraps s so that a signature, which the client only makes
+// once the server accepted the key, records name as the used credential.
+func namedSigner(s ssh.Signer, name string, c *credentials) ssh.Signer {
+	algorithmSigner, ok := s.(ssh.AlgorithmSigner)
+	if !ok {
+		return s
+	}
+
+	named := &keySigner{AlgorithmSigner: algorithmSigner, name: name, credentials: c}
+	if multi, ok := s.(ssh.MultiAlgorithmSigner); ok {
+		return &multiKeySigner{keySigner: named, algorithms: multi.Algorithms()}
+	}
+	return named
+}
+
+type (
+	keySigner struct {
+		ssh.AlgorithmSigner
+		name        string
+		credentials *credentials
+	}
+
+	multiKeySigner struct {
+		*keySigner
+		algorithms []string
+	}
+)
+
+func (s *keySigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	signature, err := s.AlgorithmSigner.Sign(rand, data)
+	if err == nil {
+		s.credentials.used = s.name
+	}
+	return signature, err
+}
+
+func (s *keySigner) SignWithAlgorithm(
+	rand io.Reader,
+	data []byte,
+	algorithm string,
+) (*ssh.Signature, error) {
+	signature, err := s.AlgorithmSigner.SignWithAlgorithm(rand, data, algorithm)
+	if err == nil {
+		s.credentials.used = s.name
+	}
+	return signature, err
+}
+
+func (s *multiKeySigner) Algorithms() []string {
+	return s.algorithms
+}
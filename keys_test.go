@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestExpandKeyPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"id_rsa", "id_rsa.pub", "id_ed25519", "id_ed25519-cert.pub"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+	}
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "id_dir"), 0o700))
+
+	paths, err := expandKeyPaths([]string{"/etc/deploy_key", "", filepath.Join(dir, "id_*")})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"/etc/deploy_key",
+		filepath.Join(dir, "id_ed25519"),
+		filepath.Join(dir, "id_rsa"),
+	}, paths)
+
+	_, err = expandKeyPaths([]string{filepath.Join(dir, "[")})
+	assert.ErrorContains(t, err, "invalid key_paths pattern")
+}
+
+func TestPrivateKeys(t *testing.T) {
+	e := endpoint{
+		KeyPath:  "./tests/.ssh/id_rsa",
+		KeyPaths: []string{"./tests/.ssh/missing", "./tests/.ssh/id_rsa"},
+		Key:      "inline",
+		Keys:     []string{"first", "second"},
+	}
+
+	var names []string
+	for _, key := range e.privateKeys() {
+		names = append(names, key.name)
+	}
+	assert.Equal(t, []string{
+		"./tests/.ssh/id_rsa",
+		"./tests/.ssh/id_rsa",
+		"key",
+		"keys[0]",
+		"keys[1]",
+	}, names)
+}
+
+func TestSignerPassphrase(t *testing.T) {
+	plain := mustReadFile(t, "./tests/.ssh/id_rsa")
+
+	_, err := signer(plain, "")
+	assert.NoError(t, err)
+
+	// a passphrase doesn't break plain keys
+	_, err = signer(plain, "1234")
+	assert.NoError(t, err)
+
+	key, err := ssh.ParseRawPrivateKey(plain)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("1234"))
+	require.NoError(t, err)
+	encrypted := pem.EncodeToMemory(block)
+
+	_, err = signer(encrypted, "1234")
+	assert.NoError(t, err)
+
+	_, err = signer(encrypted, "")
+	assert.Error(t, err)
+}
+
+func TestNamedSigner(t *testing.T) {
+	s, err := signer(mustReadFile(t, "./tests/.ssh/id_rsa"), "")
+	require.NoError(t, err)
+
+	var c credentials
+	named := namedSigner(s, "deploy", &c)
+	_, ok := named.(ssh.AlgorithmSigner)
+	assert.True(t, ok)
+
+	_, err = named.(ssh.AlgorithmSigner).SignWithAlgorithm(rand.Reader, []byte("data"), ssh.KeyAlgoRSASHA256)
+	require.NoError(t, err)
+	assert.Equal(t, "deploy", c.used)
+
+	multi, err := ssh.NewSignerWithAlgorithms(s.(ssh.AlgorithmSigner), []string{ssh.KeyAlgoRSASHA512})
+	require.NoError(t, err)
+	named = namedSigner(multi, "rotated", &c)
+	require.Implements(t, (*ssh.MultiAlgorithmSigner)(nil), named)
+	assert.Equal(t, []string{ssh.KeyAlgoRSASHA512}, named.(ssh.MultiAlgorithmSigner).Algorithms())
+
+	_, err = named.Sign(rand.Reader, []byte("data"))
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", c.used)
+}
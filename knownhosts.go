@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Values of Config.StrictHostKeyChecking, with the OpenSSH semantics.
+const (
+	// strictHostKeyYes rejects hosts that are not in known_hosts.
+	strictHostKeyYes = "yes"
+	// strictHostKeyAcceptNew learns the key of unknown hosts,
+	// a changed key is still rejected.
+	strictHostKeyAcceptNew = "accept-new"
+	// strictHostKeyNo learns the key of unknown hosts and connects to a
+	// changed key without the password, keyboard-interactive and agent
+	// forwarding.
+	strictHostKeyNo = "no"
+)
+
+// knownHosts verifies host keys against known_hosts entries. It is shared
+// by every host of a run, keys learned on one host are known to the next.
+type knownHosts struct {
+	// path is the known_hosts file learned keys are appended to,
+	// empty when only inline contents are used.
+	path   string
+	strict string
+	notice func(format string, args ...any)
+
+	mu       sync.Mutex
+	data     []byte
+	callback ssh.HostKeyCallback
+}
+
+// knownHosts loads Config.KnownHosts and Config.KnownHostsPath, it is nil
+// when neither of them nor Config.StrictHostKeyChecking is set. Without
+// entries strict_host_key_checking uses ~/.ssh/known_hosts like OpenSSH.
+func (p Plugin) knownHosts() (*knownHosts, error) {
+	strict := strings.ToLower(strings.TrimSpace(p.Config.StrictHostKeyChecking))
+	switch strict {
+	case "":
+	case strictHostKeyYes, strictHostKeyAcceptNew, strictHostKeyNo:
+	case "off":
+		strict = strictHostKeyNo
+	default:
+		return nil, fmt.Errorf(
+			"error: invalid strict_host_key_checking value %q, use yes, accept-new or no",
+			p.Config.StrictHostKeyChecking,
+		)
+	}
+
+	path := expandHome(strings.TrimSpace(p.Config.KnownHostsPath))
+	if path == "" && strings.TrimSpace(p.Config.KnownHosts) == "" {
+		if strict == "" {
+			return nil, nil
+		}
+		path = filepath.Join(homeDir(), ".ssh", "known_hosts")
+	}
+	if strict == "" {
+		strict = strictHostKeyYes
+	}
+
+	k := &knownHosts{
+		path:   path,
+		strict: strict,
+		notice: p.notice,
+		data:   []byte(p.Config.KnownHosts),
+	}
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			k.data = appendLine(k.data, string(data))
+		case errors.Is(err, fs.ErrNotExist) && strict != strictHostKeyYes:
+		default:
+			return nil, fmt.Errorf("error: can't read known_hosts: %w", err)
+		}
+	}
+
+	if err := k.load(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// load parses the entries, the knownhosts package only reads files.
+func (k *knownHosts) load() error {
+	f, err := os.CreateTemp("", "drone-ssh-known_hosts")
+	if err != nil {
+		return fmt.Errorf("error: can't load known_hosts: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(k.data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error: can't load known_hosts: %w", err)
+	}
+
+	callback, err := knownhosts.New(f.Name())
+	if err != nil {
+		return fmt.Errorf(
+			"error: can't parse known_hosts: %w",
+			errors.New(strings.ReplaceAll(err.Error(), f.Name(), "known_hosts")),
+		)
+	}
+	k.callback = callback
+
+	return nil
+}
+
+// check is the host key callback of every connection. A changed key
+// accepted with strict_host_key_checking no returns errHostKeyChanged.
+func (k *knownHosts) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	err := k.callback(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return err
+	}
+
+	// Only a known key of the same type is a changed key, other types
+	// make the key new, as in OpenSSH.
+	changed := slices.ContainsFunc(keyErr.Want, func(known knownhosts.KnownKey) bool {
+		return known.Key.Type() == key.Type()
+	})
+
+	switch {
+	case changed && k.strict == strictHostKeyNo:
+		k.notice(
+			"⚠️  the %s host key of %s does not match known_hosts, connecting anyway",
+			key.Type(),
+			hostname,
+		)
+		return errHostKeyChanged
+	case changed:
+		return fmt.Errorf(
+			"error: the %s host key of %s does not match known_hosts (%s), it may be a man-in-the-middle attack",
+			key.Type(),
+			hostname,
+			ssh.FingerprintSHA256(key),
+		)
+	case k.strict == strictHostKeyYes:
+		return fmt.Errorf(
+			"error: no %s host key of %s in known_hosts (%s) and strict_host_key_checking is yes",
+			key.Type(),
+			hostname,
+			ssh.FingerprintSHA256(key),
+		)
+	}
+
+	return k.add(hostname, key)
+}
+
+// add learns the key of a new host and appends it to the known_hosts file.
+func (k *knownHosts) add(hostname string, key ssh.PublicKey) error {
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+
+	if k.path != "" {
+		if err := appendFileLine(k.path, line); err != nil {
+			return fmt.Errorf("error: can't update known_hosts: %w", err)
+		}
+	}
+
+	k.data = appendLine(k.data, line)
+	if err := k.load(); err != nil {
+		return err
+	}
+
+	k.notice("🔑 added the %s host key of %s to known_hosts", key.Type(), hostname)
+	return nil
+}
+
+// probeKey is never in known_hosts, checking it lists the known keys.
+var probeKey, _ = ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+
+// algorithms orders the host key algorithms so that the server presents
+// a key type known for address, as OpenSSH does. Certificates are only
+// negotiated when known_hosts has @cert-authority entries.
+func (k *knownHosts) algorithms(address string) []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	var algorithms []string
+	var keyErr *knownhosts.KeyError
+	if errors.As(k.callback(address, &net.TCPAddr{}, probeKey), &keyErr) {
+		for _, known := range keyErr.Want {
+			algorithms = append(algorithms, keyAlgorithms(known.Key.Type())...)
+		}
+	}
+
+	withCerts := bytes.Contains(k.data, []byte("@cert-authority"))
+	for _, algorithm := range append(ssh.SupportedAlgorithms().HostKeys, ssh.KeyAlgoRSA) {
+		if !withCerts && strings.Contains(algorithm, "-cert-") {
+			continue
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+
+	return uniq(algorithms)
+}
+
+// keyAlgorithms returns the signature algorithms of a key type.
+func keyAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+	}
+	return []string{keyType}
+}
+
+// uniq drops repeated values and keeps the first position of each.
+func uniq(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if !slices.Contains(result, v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// appendLine appends line to data on a line of its own.
+func appendLine(data []byte, line string) []byte {
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	return append(data, line+"\n"...)
+}
+
+// appendFileLine appends line to the file at path, creating it and
+// its directory when missing.
+func appendFileLine(path, line string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	existing, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if len(existing) > 0 && existing[len(existing)-1] != '\n' {
+		line = "\n" + line
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(line + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	return key
+}
+
+func TestKnownHostsStrict(t *testing.T) {
+	known := newHostKey(t)
+	other := newHostKey(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 2222}
+
+	plugin := Plugin{
+		Config: Config{
+			KnownHosts: knownhosts.Line([]string{knownhosts.HashHostname("[foo.com]:2222")}, known) + "\n" +
+				knownhosts.Line([]string{"bar.com"}, known),
+		},
+	}
+
+	hostKeys, err := plugin.knownHosts()
+	require.NoError(t, err)
+	assert.Equal(t, strictHostKeyYes, hostKeys.strict)
+
+	assert.NoError(t, hostKeys.check("foo.com:2222", remote, known))
+	assert.NoError(t, hostKeys.check("bar.com:22", remote, known))
+	assert.ErrorContains(t, hostKeys.check("foo.com:2222", remote, other), "does not match known_hosts")
+	assert.ErrorContains(t, hostKeys.check("foo.com:22", remote, known), "strict_host_key_checking is yes")
+}
+
+func TestKnownHostsAcceptNew(t *testing.T) {
+	known := newHostKey(t)
+	other := newHostKey(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+	path := filepath.Join(t.TempDir(), ".ssh", "known_hosts")
+
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			KnownHostsPath:        path,
+			StrictHostKeyChecking: "accept-new",
+		},
+		Writer: &buffer,
+	}
+
+	hostKeys, err := plugin.knownHosts()
+	require.NoError(t, err)
+
+	require.NoError(t, hostKeys.check("foo.com:22", remote, known))
+	assert.Contains(t, buffer.String(), "added the ssh-ed25519 host key of foo.com:22")
+	assert.NoError(t, hostKeys.check("foo.com:22", remote, known))
+	assert.ErrorContains(t, hostKeys.check("foo.com:22", remote, other), "does not match known_hosts")
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, knownhosts.Line([]string{"foo.com"}, known)+"\n", string(data))
+
+	// a new run reads the learned key back
+	hostKeys, err = plugin.knownHosts()
+	require.NoError(t, err)
+	assert.ErrorContains(t, hostKeys.check("foo.com:22", remote, other), "does not match known_hosts")
+}
+
+func TestKnownHostsNo(t *testing.T) {
+	known := newHostKey(t)
+	other := newHostKey(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			KnownHosts:            knownhosts.Line([]string{"foo.com"}, known),
+			StrictHostKeyChecking: "no",
+		},
+		Writer: &buffer,
+	}
+
+	hostKeys, err := plugin.knownHosts()
+	require.NoError(t, err)
+
+	assert.ErrorIs(t, hostKeys.check("foo.com:22", remote, other), errHostKeyChanged)
+	assert.Contains(t, buffer.String(), "does not match known_hosts, connecting anyway")
+	assert.NoError(t, hostKeys.check("bar.com:22", remote, other))
+}
+
+func TestKnownHostsNoChangedKey(t *testing.T) {
+	server := newTransportServer(t)
+	host, port, err := net.SplitHostPort(server.addr)
+	require.NoError(t, err)
+	address := knownhosts.Normalize(net.JoinHostPort(host, port))
+
+	var buffer bytes.Buffer
+	plugin := transportPlugin(t, server.addr, &buffer)
+	plugin.Config.KnownHosts = knownhosts.Line([]string{address}, server.hostKey)
+	plugin.Config.StrictHostKeyChecking = "no"
+	require.NoError(t, plugin.Exec())
+
+	// the password and the agent must not reach a changed key
+	plugin.Config.KnownHosts = knownhosts.Line([]string{address}, newHostKey(t))
+	assert.ErrorContains(t, plugin.Exec(), errHostKeyChanged.Error())
+
+	plugin.Config.Password = ""
+	plugin.Config.KeyPath = "./tests/.ssh/id_rsa"
+	require.NoError(t, plugin.Exec())
+
+	plugin.Config.AgentForward = true
+	assert.ErrorContains(t, plugin.Exec(), errHostKeyChanged.Error())
+}
+
+func TestKnownHostsSettings(t *testing.T) {
+	hostKeys, err := Plugin{}.knownHosts()
+	assert.NoError(t, err)
+	assert.Nil(t, hostKeys)
+
+	_, err = Plugin{Config: Config{StrictHostKeyChecking: "ask"}}.knownHosts()
+	assert.ErrorContains(t, err, "invalid strict_host_key_checking")
+
+	_, err = Plugin{Config: Config{KnownHosts: "foo.com ssh-ed25519 !!!"}}.knownHosts()
+	assert.ErrorContains(t, err, "can't parse known_hosts")
+
+	_, err = Plugin{Config: Config{
+		KnownHostsPath: filepath.Join(t.TempDir(), "missing"),
+	}}.knownHosts()
+	assert.ErrorContains(t, err, "can't read known_hosts")
+}
+
+func TestKnownHostsAlgorithms(t *testing.T) {
+	signer, err := ssh.ParsePrivateKey(mustReadFile(t, "./tests/.ssh/id_rsa"))
+	require.NoError(t, err)
+
+	plugin := Plugin{
+		Config: Config{
+			KnownHosts: knownhosts.Line([]string{"foo.com"}, signer.PublicKey()),
+		},
+	}
+
+	hostKeys, err := plugin.knownHosts()
+	require.NoError(t, err)
+
+	algorithms := hostKeys.algorithms("foo.com:22")
+	assert.Equal(t, []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}, algorithms[:3])
+	assert.Contains(t, algorithms, ssh.KeyAlgoED25519)
+	assert.NotContains(t, algorithms, ssh.CertAlgoED25519v01)
+
+	assert.Equal(t, ssh.KeyAlgoRSASHA256, hostKeys.algorithms("bar.com:22")[0])
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return data
+}
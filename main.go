@@ -45,6 +45,46 @@ func main() {
 			EnvVars:  []string{"PLUGIN_HOST", "SSH_HOST", "INPUT_HOST"},
 			FilePath: ".host",
 		},
+		&cli.StringFlag{
+			Name:    "hosts",
+			Usage:   "JSON or YAML list of hosts with their own username, port, credentials, proxy and envs",
+			EnvVars: []string{"PLUGIN_HOSTS", "SSH_HOSTS", "INPUT_HOSTS"},
+		},
+		&cli.StringFlag{
+			Name:    "hosts-file",
+			Usage:   "file with more hosts, one or more per line, # starts a comment",
+			EnvVars: []string{"PLUGIN_HOSTS_FILE", "SSH_HOSTS_FILE", "INPUT_HOSTS_FILE"},
+		},
+		&cli.StringFlag{
+			Name:    "hosts-command",
+			Usage:   "local command printing more hosts, one or more per line",
+			EnvVars: []string{"PLUGIN_HOSTS_COMMAND", "SSH_HOSTS_COMMAND", "INPUT_HOSTS_COMMAND"},
+		},
+		&cli.DurationFlag{
+			Name:  "hosts-command-timeout",
+			Usage: "hosts_command timeout",
+			EnvVars: []string{
+				"PLUGIN_HOSTS_COMMAND_TIMEOUT",
+				"SSH_HOSTS_COMMAND_TIMEOUT",
+				"INPUT_HOSTS_COMMAND_TIMEOUT",
+			},
+			Value: time.Minute,
+		},
+		&cli.BoolFlag{
+			Name:    "resolve-all",
+			Usage:   "run on every A and AAAA address of each host, filtered by the protocol",
+			EnvVars: []string{"PLUGIN_RESOLVE_ALL", "SSH_RESOLVE_ALL", "INPUT_RESOLVE_ALL"},
+		},
+		&cli.StringFlag{
+			Name:    "inventory",
+			Usage:   "Ansible inventory file, INI or YAML, to read the hosts from",
+			EnvVars: []string{"PLUGIN_INVENTORY", "SSH_INVENTORY", "INPUT_INVENTORY"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "group",
+			Usage:   "groups of the inventory to run on, all hosts when not set",
+			EnvVars: []string{"PLUGIN_GROUP", "SSH_GROUP", "INPUT_GROUP"},
+		},
 		&cli.IntFlag{
 			Name:    "port",
 			Aliases: []string{"p"},
@@ -63,7 +103,7 @@ func main() {
 			Aliases: []string{"user", "u"},
 			Usage:   "connect as user",
 			EnvVars: []string{"PLUGIN_USERNAME", "PLUGIN_USER", "SSH_USERNAME", "INPUT_USERNAME"},
-			Value:   "root",
+			Value:   defaultUsername,
 		},
 		&cli.StringFlag{
 			Name:    "password",
@@ -71,6 +111,15 @@ func main() {
 			Usage:   "user password",
 			EnvVars: []string{"PLUGIN_PASSWORD", "SSH_PASSWORD", "INPUT_PASSWORD"},
 		},
+		&cli.StringSliceFlag{
+			Name:  "keyboard-interactive",
+			Usage: "keyboard-interactive answers as prompt regex=answer, $NAME reads the environment",
+			EnvVars: []string{
+				"PLUGIN_KEYBOARD_INTERACTIVE",
+				"SSH_KEYBOARD_INTERACTIVE",
+				"INPUT_KEYBOARD_INTERACTIVE",
+			},
+		},
 		&cli.DurationFlag{
 			Name:    "timeout",
 			Aliases: []string{"t"},
@@ -99,6 +148,36 @@ func main() {
 			Usage:   "ssh private key path",
 			EnvVars: []string{"PLUGIN_KEY_PATH", "SSH_KEY_PATH", "INPUT_KEY_PATH"},
 		},
+		&cli.StringFlag{
+			Name:    "ssh-cert",
+			Usage:   "OpenSSH user certificate signed for the private key",
+			EnvVars: []string{"PLUGIN_SSH_CERT", "PLUGIN_CERT", "SSH_CERT", "INPUT_SSH_CERT"},
+		},
+		&cli.StringFlag{
+			Name:    "ssh-cert-path",
+			Usage:   "OpenSSH user certificate path, e.g. id_ed25519-cert.pub",
+			EnvVars: []string{"PLUGIN_SSH_CERT_PATH", "SSH_CERT_PATH", "INPUT_SSH_CERT_PATH"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "ssh-keys",
+			Usage:   "more private ssh keys, tried in order after ssh-key and key-path",
+			EnvVars: []string{"PLUGIN_SSH_KEYS", "PLUGIN_KEYS", "SSH_KEYS", "INPUT_KEYS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "key-paths",
+			Usage:   "more ssh private key paths or glob patterns, tried in order",
+			EnvVars: []string{"PLUGIN_KEY_PATHS", "SSH_KEY_PATHS", "INPUT_KEY_PATHS"},
+		},
+		&cli.BoolFlag{
+			Name:    "use-agent",
+			Usage:   "authenticate with the identities of the ssh-agent on SSH_AUTH_SOCK, detected automatically when the socket is set",
+			EnvVars: []string{"PLUGIN_USE_AGENT", "SSH_USE_AGENT", "INPUT_USE_AGENT"},
+		},
+		&cli.BoolFlag{
+			Name:    "agent-forward",
+			Usage:   "forward the ssh-agent, or without one the private key, to the remote session",
+			EnvVars: []string{"PLUGIN_AGENT_FORWARD", "SSH_AGENT_FORWARD", "INPUT_AGENT_FORWARD"},
+		},
 		&cli.StringSliceFlag{
 			Name:    "ciphers",
 			Usage:   "The allowed cipher algorithms. If unspecified then a sensible",
@@ -118,6 +197,35 @@ func main() {
 			Usage:   "fingerprint SHA256 of the host public key, default is to skip verification",
 			EnvVars: []string{"PLUGIN_FINGERPRINT", "SSH_FINGERPRINT", "INPUT_FINGERPRINT"},
 		},
+		&cli.StringSliceFlag{
+			Name:    "fingerprints",
+			Usage:   "per host SHA256 fingerprints, host=SHA256:... entries or a list aligned with the host list",
+			EnvVars: []string{"PLUGIN_FINGERPRINTS", "SSH_FINGERPRINTS", "INPUT_FINGERPRINTS"},
+		},
+		&cli.StringFlag{
+			Name:    "host-ca-key",
+			Usage:   "public keys of the SSH host CA, host certificates signed by it are trusted",
+			EnvVars: []string{"PLUGIN_HOST_CA_KEY", "SSH_HOST_CA_KEY", "INPUT_HOST_CA_KEY"},
+		},
+		&cli.StringFlag{
+			Name:    "known-hosts",
+			Usage:   "known_hosts contents verifying the host keys, hashed, [host]:port and @cert-authority entries are supported",
+			EnvVars: []string{"PLUGIN_KNOWN_HOSTS", "SSH_KNOWN_HOSTS", "INPUT_KNOWN_HOSTS"},
+		},
+		&cli.StringFlag{
+			Name:    "known-hosts-path",
+			Usage:   "path of a known_hosts file verifying the host keys, learned keys are written back to it",
+			EnvVars: []string{"PLUGIN_KNOWN_HOSTS_PATH", "SSH_KNOWN_HOSTS_PATH", "INPUT_KNOWN_HOSTS_PATH"},
+		},
+		&cli.StringFlag{
+			Name:  "strict-host-key-checking",
+			Usage: "yes rejects unknown hosts, accept-new learns them, no also accepts changed keys without password auth",
+			EnvVars: []string{
+				"PLUGIN_STRICT_HOST_KEY_CHECKING",
+				"SSH_STRICT_HOST_KEY_CHECKING",
+				"INPUT_STRICT_HOST_KEY_CHECKING",
+			},
+		},
 		&cli.BoolFlag{
 			Name:    "sync",
 			Usage:   "sync mode",
@@ -160,6 +268,29 @@ func main() {
 			Usage:   "connect to host of proxy",
 			EnvVars: []string{"PLUGIN_PROXY_HOST", "PROXY_SSH_HOST", "INPUT_PROXY_HOST"},
 		},
+		&cli.StringFlag{
+			Name:  "network-proxy",
+			Usage: "socks5:// or http:// proxy URL the SSH connections are tunnelled through",
+			EnvVars: []string{
+				"PLUGIN_NETWORK_PROXY",
+				"SSH_NETWORK_PROXY",
+				"INPUT_NETWORK_PROXY",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "proxy-command",
+			Usage: "command whose stdin and stdout carry the SSH connection, %h, %p and %r are substituted",
+			EnvVars: []string{
+				"PLUGIN_PROXY_COMMAND",
+				"SSH_PROXY_COMMAND",
+				"INPUT_PROXY_COMMAND",
+			},
+		},
+		&cli.StringFlag{
+			Name:    "proxy.jump",
+			Usage:   "jump hosts as user@host:port entries separated by commas, or a JSON list of hops",
+			EnvVars: []string{"PLUGIN_PROXY_JUMP", "PROXY_SSH_JUMP", "INPUT_PROXY_JUMP"},
+		},
 		&cli.StringFlag{
 			Name:    "proxy.port",
 			Usage:   "connect to port of proxy",
@@ -225,6 +356,25 @@ func main() {
 				"INPUT_PROXY_KEY_PATH",
 			},
 		},
+		&cli.StringFlag{
+			Name:  "proxy.ssh-cert",
+			Usage: "OpenSSH user certificate of proxy",
+			EnvVars: []string{
+				"PLUGIN_PROXY_SSH_CERT",
+				"PLUGIN_PROXY_CERT",
+				"PROXY_SSH_CERT",
+				"INPUT_PROXY_SSH_CERT",
+			},
+		},
+		&cli.StringFlag{
+			Name:  "proxy.ssh-cert-path",
+			Usage: "OpenSSH user certificate path of proxy",
+			EnvVars: []string{
+				"PLUGIN_PROXY_SSH_CERT_PATH",
+				"PROXY_SSH_CERT_PATH",
+				"INPUT_PROXY_SSH_CERT_PATH",
+			},
+		},
 		&cli.DurationFlag{
 			Name:    "proxy.timeout",
 			Usage:   "proxy connection timeout",
@@ -254,6 +404,24 @@ func main() {
 				"INPUT_PROXY_FINGERPRINT",
 			},
 		},
+		&cli.StringFlag{
+			Name:  "proxy.host-ca-key",
+			Usage: "public keys of the SSH host CA trusted for the proxy",
+			EnvVars: []string{
+				"PLUGIN_PROXY_HOST_CA_KEY",
+				"PROXY_SSH_HOST_CA_KEY",
+				"INPUT_PROXY_HOST_CA_KEY",
+			},
+		},
+		&cli.StringSliceFlag{
+			Name:  "proxy.keyboard-interactive",
+			Usage: "keyboard-interactive answers of proxy as prompt regex=answer",
+			EnvVars: []string{
+				"PLUGIN_PROXY_KEYBOARD_INTERACTIVE",
+				"PROXY_SSH_KEYBOARD_INTERACTIVE",
+				"INPUT_PROXY_KEYBOARD_INTERACTIVE",
+			},
+		},
 		&cli.StringSliceFlag{
 			Name:    "envs",
 			Usage:   "pass environment variable to shell script",
@@ -280,6 +448,81 @@ func main() {
 			Usage:   "request a pseudo-terminal from the server",
 			EnvVars: []string{"PLUGIN_REQUEST_PTY", "INPUT_REQUEST_PTY"},
 		},
+		&cli.BoolFlag{
+			Name:    "summary",
+			Usage:   "print a per-host summary table at the end of the run",
+			EnvVars: []string{"PLUGIN_SUMMARY", "INPUT_SUMMARY"},
+		},
+		&cli.IntFlag{
+			Name:    "max-parallel",
+			Usage:   "maximum number of hosts running the script at the same time, 0 means unlimited",
+			EnvVars: []string{"PLUGIN_MAX_PARALLEL", "INPUT_MAX_PARALLEL"},
+		},
+		&cli.IntFlag{
+			Name:    "batch-size",
+			Usage:   "roll out to this many hosts at a time, a failed batch halts the rollout",
+			EnvVars: []string{"PLUGIN_BATCH_SIZE", "INPUT_BATCH_SIZE"},
+		},
+		&cli.DurationFlag{
+			Name:    "batch-pause",
+			Usage:   "pause between two batches",
+			EnvVars: []string{"PLUGIN_BATCH_PAUSE", "INPUT_BATCH_PAUSE"},
+		},
+		&cli.BoolFlag{
+			Name:    "canary",
+			Usage:   "run the script on the first host alone before the others",
+			EnvVars: []string{"PLUGIN_CANARY", "INPUT_CANARY"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "canary-hosts",
+			Usage:   "hosts of the host list that run the script before the others",
+			EnvVars: []string{"PLUGIN_CANARY_HOSTS", "INPUT_CANARY_HOSTS"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "canary-verify",
+			Usage:   "commands that must pass on the canary hosts before the others run",
+			EnvVars: []string{"PLUGIN_CANARY_VERIFY", "INPUT_CANARY_VERIFY"},
+		},
+		&cli.StringFlag{
+			Name:    "max-failures",
+			Usage:   "number or percentage (e.g. 10%) of hosts allowed to fail without failing the step",
+			EnvVars: []string{"PLUGIN_MAX_FAILURES", "INPUT_MAX_FAILURES"},
+		},
+		&cli.StringFlag{
+			Name:    "min-success",
+			Usage:   "percentage of hosts that must succeed for the step to pass, e.g. 90%",
+			EnvVars: []string{"PLUGIN_MIN_SUCCESS", "INPUT_MIN_SUCCESS"},
+		},
+		&cli.BoolFlag{
+			Name:    "failover",
+			Usage:   "run the script only on the first reachable host, in host list order",
+			EnvVars: []string{"PLUGIN_FAILOVER", "INPUT_FAILOVER"},
+		},
+		&cli.IntFlag{
+			Name:    "connect-retries",
+			Usage:   "retry a failed connection or handshake this many times, the command itself is never retried",
+			EnvVars: []string{"PLUGIN_CONNECT_RETRIES", "INPUT_CONNECT_RETRIES"},
+		},
+		&cli.DurationFlag{
+			Name:    "connect-retry-backoff",
+			Usage:   "pause before the first connection retry, doubled on every further attempt",
+			EnvVars: []string{"PLUGIN_CONNECT_RETRY_BACKOFF", "INPUT_CONNECT_RETRY_BACKOFF"},
+			Value:   defaultRetryBackoff,
+		},
+		&cli.DurationFlag{
+			Name:  "connect-retry-max-backoff",
+			Usage: "maximum pause between two connection attempts",
+			EnvVars: []string{
+				"PLUGIN_CONNECT_RETRY_MAX_BACKOFF",
+				"INPUT_CONNECT_RETRY_MAX_BACKOFF",
+			},
+			Value: defaultRetryMaxBackoff,
+		},
+		&cli.StringFlag{
+			Name:    "ssh-config",
+			Usage:   "path of an OpenSSH client config file resolving host aliases, explicit settings take precedence",
+			EnvVars: []string{"PLUGIN_SSH_CONFIG", "SSH_CONFIG", "INPUT_SSH_CONFIG"},
+		},
 	}
 
 	// Override a template
@@ -340,27 +583,60 @@ func run(c *cli.Context) error {
 
 	plugin := Plugin{
 		Config: Config{
-			Key:               c.String("ssh-key"),
-			KeyPath:           c.String("key-path"),
-			Username:          c.String("user"),
-			Password:          c.String("password"),
-			Passphrase:        c.String("ssh-passphrase"),
-			Fingerprint:       c.String("fingerprint"),
-			Host:              c.StringSlice("host"),
-			Port:              c.Int("port"),
-			Protocol:          easyssh.Protocol(c.String("protocol")),
-			Timeout:           c.Duration("timeout"),
-			CommandTimeout:    c.Duration("command.timeout"),
-			Script:            scripts,
-			ScriptStop:        c.Bool("script.stop"),
-			Envs:              c.StringSlice("envs"),
-			EnvsFormat:        c.String("envs.format"),
-			Debug:             c.Bool("debug"),
-			Sync:              c.Bool("sync"),
-			Ciphers:           c.StringSlice("ciphers"),
-			UseInsecureCipher: c.Bool("useInsecureCipher"),
-			AllEnvs:           c.Bool("allenvs"),
-			RequireTty:        c.Bool("request-pty"),
+			Key:                    c.String("ssh-key"),
+			KeyPath:                c.String("key-path"),
+			Keys:                   c.StringSlice("ssh-keys"),
+			KeyPaths:               c.StringSlice("key-paths"),
+			Cert:                   c.String("ssh-cert"),
+			CertPath:               c.String("ssh-cert-path"),
+			UseAgent:               c.Bool("use-agent"),
+			AgentForward:           c.Bool("agent-forward"),
+			Username:               c.String("user"),
+			Password:               c.String("password"),
+			KeyboardInteractive:    c.StringSlice("keyboard-interactive"),
+			Passphrase:             c.String("ssh-passphrase"),
+			Fingerprint:            c.String("fingerprint"),
+			Fingerprints:           c.StringSlice("fingerprints"),
+			HostCAKey:              c.String("host-ca-key"),
+			KnownHosts:             c.String("known-hosts"),
+			KnownHostsPath:         c.String("known-hosts-path"),
+			StrictHostKeyChecking:  c.String("strict-host-key-checking"),
+			Host:                   c.StringSlice("host"),
+			HostList:               c.String("hosts"),
+			HostsFile:              c.String("hosts-file"),
+			HostsCommand:           c.String("hosts-command"),
+			HostsCommandTimeout:    c.Duration("hosts-command-timeout"),
+			Inventory:              c.String("inventory"),
+			ResolveAll:             c.Bool("resolve-all"),
+			Groups:                 c.StringSlice("group"),
+			Port:                   c.Int("port"),
+			Protocol:               easyssh.Protocol(c.String("protocol")),
+			Timeout:                c.Duration("timeout"),
+			CommandTimeout:         c.Duration("command.timeout"),
+			Script:                 scripts,
+			ScriptStop:             c.Bool("script.stop"),
+			Envs:                   c.StringSlice("envs"),
+			EnvsFormat:             c.String("envs.format"),
+			Debug:                  c.Bool("debug"),
+			Sync:                   c.Bool("sync"),
+			Ciphers:                c.StringSlice("ciphers"),
+			UseInsecureCipher:      c.Bool("useInsecureCipher"),
+			AllEnvs:                c.Bool("allenvs"),
+			RequireTty:             c.Bool("request-pty"),
+			Summary:                c.Bool("summary"),
+			MaxParallel:            c.Int("max-parallel"),
+			BatchSize:              c.Int("batch-size"),
+			BatchPause:             c.Duration("batch-pause"),
+			Canary:                 c.Bool("canary"),
+			CanaryHosts:            c.StringSlice("canary-hosts"),
+			CanaryVerify:           c.StringSlice("canary-verify"),
+			MaxFailures:            c.String("max-failures"),
+			MinSuccess:             c.String("min-success"),
+			Failover:               c.Bool("failover"),
+			ConnectRetries:         c.Int("connect-retries"),
+			ConnectRetryBackoff:    c.Duration("connect-retry-backoff"),
+			ConnectRetryMaxBackoff: c.Duration("connect-retry-max-backoff"),
+			SSHConfig:              c.String("ssh-config"),
 			Proxy: easyssh.DefaultConfig{
 				Key:               c.String("proxy.ssh-key"),
 				KeyPath:           c.String("proxy.key-path"),
@@ -375,10 +651,33 @@ func run(c *cli.Context) error {
 				Ciphers:           c.StringSlice("proxy.ciphers"),
 				UseInsecureCipher: c.Bool("proxy.useInsecureCipher"),
 			},
+			ProxyCert:                c.String("proxy.ssh-cert"),
+			ProxyCertPath:            c.String("proxy.ssh-cert-path"),
+			ProxyHostCAKey:           c.String("proxy.host-ca-key"),
+			ProxyKeyboardInteractive: c.StringSlice("proxy.keyboard-interactive"),
+			ProxyJump:                c.String("proxy.jump"),
+			NetworkProxy:             c.String("network-proxy"),
+			ProxyCommand:             c.String("proxy-command"),
 		},
 		Writer: os.Stdout,
 	}
 
+	// default values must not hide the User and Port of the ssh_config file
+	if plugin.Config.SSHConfig != "" {
+		if !c.IsSet("username") {
+			plugin.Config.Username = ""
+		}
+		if !c.IsSet("port") {
+			plugin.Config.Port = 0
+		}
+		if !c.IsSet("proxy.username") {
+			plugin.Config.Proxy.User = ""
+		}
+		if !c.IsSet("proxy.port") {
+			plugin.Config.Proxy.Port = ""
+		}
+	}
+
 	if plugin.Config.Debug {
 		_ = godump.Dump(plugin)
 	}
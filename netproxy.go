@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// networkProxy is the SOCKS5 or HTTP CONNECT proxy of Config.NetworkProxy,
+// the first SSH hop of every host is dialed through it.
+type networkProxy struct {
+	url *url.URL
+}
+
+// socks5Replies are the failure messages of the SOCKS5 reply codes.
+var socks5Replies = map[byte]string{
+	1: "general SOCKS server failure",
+	2: "connection not allowed by ruleset",
+	3: "network unreachable",
+	4: "host unreachable",
+	5: "connection refused",
+	6: "TTL expired",
+	7: "command not supported",
+	8: "address type not supported",
+}
+
+// parseNetworkProxy reads Config.NetworkProxy, it is nil when not set.
+// socks5, socks5h, http and https URLs are supported, the proxy always
+// resolves the host names of the targets.
+func parseNetworkProxy(raw string) (*networkProxy, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error: invalid network_proxy: %w", err)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	switch u.Scheme {
+	case "socks5", "socks5h", "http", "https":
+	default:
+		return nil, fmt.Errorf(
+			"error: invalid network_proxy %q, use a socks5://, http:// or https:// URL",
+			u.Redacted(),
+		)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("error: network_proxy %q has no host", u.Redacted())
+	}
+	if port := u.Port(); port != "" {
+		if _, err := strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("error: invalid network_proxy port %q", port)
+		}
+	}
+
+	return &networkProxy{url: u}, nil
+}
+
+// address returns the host:port of the proxy, with the default port of
+// its scheme when the URL has none.
+func (n *networkProxy) address() string {
+	port := n.url.Port()
+	if port == "" {
+		switch n.url.Scheme {
+		case "http":
+			port = "80"
+		case "https":
+			port = "443"
+		default:
+			port = "1080"
+		}
+	}
+	return net.JoinHostPort(n.url.Hostname(), port)
+}
+
+// dial connects to address, through the proxy when n is not nil. The
+// timeout bounds the connection to the proxy and its handshake.
+func (n *networkProxy) dial(network, address string, timeout time.Duration) (net.Conn, error) {
+	if n == nil {
+		return net.DialTimeout(network, address, timeout)
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.Dial("tcp", n.address())
+	if err != nil {
+		return nil, fmt.Errorf("network_proxy %s: %w", n.url.Redacted(), err)
+	}
+	if n.url.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: n.url.Hostname()})
+	}
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if strings.HasPrefix(n.url.Scheme, "socks5") {
+		err = n.socks5Connect(conn, address)
+	} else {
+		conn, err = n.httpConnect(conn, address)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("network_proxy %s: %w", n.url.Redacted(), err)
+	}
+
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// socks5Connect asks a SOCKS5 proxy for a connection to address, with
+// username and password authentication when the URL has a user (RFC 1928,
+// RFC 1929).
+func (n *networkProxy) socks5Connect(conn net.Conn, address string) error {
+	host, portValue, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portValue)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portValue)
+	}
+
+	methods := []byte{0x00}
+	if n.url.User != nil {
+		methods = []byte{0x00, 0x02}
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return errors.New("not a SOCKS5 proxy")
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if n.url.User == nil {
+			return errors.New("the SOCKS5 proxy asks for a username and password")
+		}
+		user := n.url.User.Username()
+		password, _ := n.url.User.Password()
+		if len(user) > 255 || len(password) > 255 {
+			return errors.New("the SOCKS5 username and password are limited to 255 bytes")
+		}
+
+		auth := append([]byte{0x01, byte(len(user))}, user...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("SOCKS5 authentication failed")
+		}
+	default:
+		return errors.New("no acceptable SOCKS5 authentication method")
+	}
+
+	request := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("host name %q is too long", host)
+		}
+		request = append(request, 0x03, byte(len(host)))
+		request = append(request, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		request = append(request, 0x01)
+		request = append(request, ip4...)
+	} else {
+		request = append(request, 0x04)
+		request = append(request, ip.To16()...)
+	}
+	request = append(request, byte(port>>8), byte(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		message, ok := socks5Replies[header[1]]
+		if !ok {
+			message = fmt.Sprintf("reply code %d", header[1])
+		}
+		return fmt.Errorf("can't connect to %s: %s", address, message)
+	}
+
+	// skip the bound address and port
+	var size int
+	switch header[3] {
+	case 0x01:
+		size = net.IPv4len
+	case 0x04:
+		size = net.IPv6len
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		size = int(length[0])
+	default:
+		return fmt.Errorf("unknown SOCKS5 address type %d", header[3])
+	}
+	_, err = io.ReadFull(conn, make([]byte, size+2))
+	return err
+}
+
+// httpConnect opens a tunnel to address with the HTTP CONNECT method,
+// with basic authentication when the URL has a user.
+func (n *networkProxy) httpConnect(conn net.Conn, address string) (net.Conn, error) {
+	request := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if n.url.User != nil {
+		password, _ := n.url.User.Password()
+		userinfo := n.url.User.Username() + ":" + password
+		request.Header.Set(
+			"Proxy-Authorization",
+			"Basic "+base64.StdEncoding.EncodeToString([]byte(userinfo)),
+		)
+	}
+	if err := request.Write(conn); err != nil {
+		return conn, err
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, request)
+	if err != nil {
+		return conn, err
+	}
+	// the body of a CONNECT response is the tunnel, it is never read
+	if response.StatusCode/100 != 2 {
+		return conn, fmt.Errorf("can't connect to %s: %s", address, response.Status)
+	}
+
+	// the server may have sent its SSH banner with the response
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads the bytes buffered after the CONNECT response first.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
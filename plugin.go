@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"net/netip"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -19,34 +23,106 @@ var (
 		"error: can't connect without a private SSH key or password",
 	)
 	errCommandTimeOut = errors.New("error: command timeout")
-	envsFormat        = "export {NAME}={VALUE}"
+	errInvalidRollout = errors.New(
+		"error: max_parallel, batch_size and batch_pause can't be negative",
+	)
+	errInvalidFailover = errors.New(
+		"error: failover can't be combined with canary or batch_size",
+	)
+	errMissingAgent = errors.New(
+		"error: use_agent is set but SSH_AUTH_SOCK is empty",
+	)
+	errAgentForward = errors.New(
+		"error: agent_forward needs an ssh-agent on SSH_AUTH_SOCK or a private key",
+	)
+	errCertKeyMismatch = errors.New(
+		"error: the certificate does not match the private key",
+	)
+	errMixedFingerprints = errors.New(
+		"error: fingerprints can't mix host=fingerprint entries with a list aligned with the hosts",
+	)
+	errProxyJumpWithProxy = errors.New(
+		"error: proxy_jump can't be combined with proxy_host",
+	)
+	errProxyJumpFingerprint = errors.New(
+		"error: proxy_fingerprint only fits a single proxy_jump hop, " +
+			"give each hop its fingerprint in the JSON list of hops",
+	)
+	errProxyCommandWithNetworkProxy = errors.New(
+		"error: proxy_command can't be combined with network_proxy",
+	)
+	errHostKeyChanged = errors.New(
+		"error: the host key does not match known_hosts, password, keyboard-interactive " +
+			"and agent forwarding are disabled to avoid man-in-the-middle attacks",
+	)
+	envsFormat = "export {NAME}={VALUE}"
 )
 
 type (
 	// Config for the plugin.
 	Config struct {
-		Key               string
-		Passphrase        string
-		KeyPath           string
-		Username          string
-		Password          string
-		Host              []string
-		Port              int
-		Protocol          easyssh.Protocol
-		Fingerprint       string
-		Timeout           time.Duration
-		CommandTimeout    time.Duration
-		Script            []string
-		ScriptStop        bool
-		Envs              []string
-		Proxy             easyssh.DefaultConfig
-		Debug             bool
-		Sync              bool
-		Ciphers           []string
-		UseInsecureCipher bool
-		EnvsFormat        string
-		AllEnvs           bool
-		RequireTty        bool
+		Key                      string
+		Passphrase               string
+		UseAgent                 bool
+		AgentForward             bool
+		KeyPath                  string
+		Keys                     []string
+		KeyPaths                 []string
+		Cert                     string
+		CertPath                 string
+		Username                 string
+		Password                 string
+		KeyboardInteractive      []string
+		Host                     []string
+		HostList                 string
+		HostsFile                string
+		HostsCommand             string
+		HostsCommandTimeout      time.Duration
+		Inventory                string
+		ResolveAll               bool
+		Groups                   []string
+		Port                     int
+		Protocol                 easyssh.Protocol
+		Fingerprint              string
+		Fingerprints             []string
+		HostCAKey                string
+		KnownHosts               string
+		KnownHostsPath           string
+		StrictHostKeyChecking    string
+		Timeout                  time.Duration
+		CommandTimeout           time.Duration
+		Script                   []string
+		ScriptStop               bool
+		Envs                     []string
+		Proxy                    easyssh.DefaultConfig
+		ProxyCert                string
+		ProxyCertPath            string
+		ProxyHostCAKey           string
+		ProxyKeyboardInteractive []string
+		ProxyJump                string
+		NetworkProxy             string
+		ProxyCommand             string
+		Debug                    bool
+		Sync                     bool
+		Ciphers                  []string
+		UseInsecureCipher        bool
+		EnvsFormat               string
+		AllEnvs                  bool
+		RequireTty               bool
+		Summary                  bool
+		MaxParallel              int
+		BatchSize                int
+		BatchPause               time.Duration
+		Canary                   bool
+		CanaryHosts              []string
+		CanaryVerify             []string
+		MaxFailures              string
+		MinSuccess               string
+		Failover                 bool
+		SSHConfig                string
+		ConnectRetries           int
+		ConnectRetryBackoff      time.Duration
+		ConnectRetryMaxBackoff   time.Duration
 	}
 
 	// Plugin structure
@@ -54,59 +130,129 @@ type (
 		Config Config
 		Writer io.Writer
 	}
+
+	// HostError is the failure of a single host.
+	HostError struct {
+		Host string
+		Err  error
+	}
+
+	// MultiHostError combines the failures of every host in a run.
+	// Use errors.As to inspect it and errors.Is to match a cause.
+	MultiHostError struct {
+		Errors []*HostError
+	}
 )
 
+func (e *HostError) Error() string {
+	return e.Host + ": " + e.Err.Error()
+}
+
+func (e *HostError) Unwrap() error {
+	return e.Err
+}
+
+func (e *MultiHostError) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+
+	messages := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+
+	return fmt.Sprintf("error: %d hosts failed:\n%s", len(e.Errors), strings.Join(messages, "\n"))
+}
+
+func (e *MultiHostError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
 func escapeArg(arg string) string {
 	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
 }
 
-func (p Plugin) hostPort(host string) (string, string) {
-	hosts := strings.Split(host, ":")
-	port := strconv.Itoa(p.Config.Port)
-	if len(hosts) > 1 &&
-		(p.Config.Protocol == easyssh.PROTOCOL_TCP ||
-			p.Config.Protocol == easyssh.PROTOCOL_TCP4) {
-		host = hosts[0]
-		port = hosts[1]
+// parseHostPort splits an entry of Config.Host for every protocol: host,
+// host:port, a bare IPv6 address or a bracketed [address]:port. A missing
+// port is empty.
+func parseHostPort(entry string) (string, string, error) {
+	host, port := entry, ""
+	switch {
+	case strings.HasPrefix(entry, "["):
+		end := strings.Index(entry, "]")
+		if end < 0 {
+			return "", "", errors.New("missing ] after the IPv6 address")
+		}
+		host = entry[1:end]
+		rest := entry[end+1:]
+		if rest != "" {
+			var ok bool
+			if port, ok = strings.CutPrefix(rest, ":"); !ok {
+				return "", "", fmt.Errorf("unexpected %q after ]", rest)
+			}
+			if port == "" {
+				return "", "", errors.New("missing port after ]:")
+			}
+		}
+		if strings.Contains(host, ":") && !isIPv6(host) {
+			return "", "", fmt.Errorf("%q is not an IPv6 address", host)
+		}
+	case strings.Count(entry, ":") == 1:
+		host, port, _ = strings.Cut(entry, ":")
+		if port == "" {
+			return "", "", errors.New("missing port after :")
+		}
+	case strings.Contains(entry, ":"):
+		if !isIPv6(entry) {
+			return "", "", errors.New(
+				"too many colons, write IPv6 addresses with a port as [2001:db8::1]:22",
+			)
+		}
+	}
+
+	if host == "" {
+		return "", "", errors.New("missing host")
+	}
+	if strings.ContainsAny(host, "[] \t") {
+		return "", "", fmt.Errorf("invalid character in %q", host)
+	}
+
+	if port != "" {
+		if err := checkPort(port); err != nil {
+			return "", "", err
+		}
 	}
 
-	return host, port
+	return host, port, nil
 }
 
-func (p Plugin) exec(host string, wg *sync.WaitGroup, errChannel chan error) {
-	defer wg.Done()
-	host, port := p.hostPort(host)
-	// Create MakeConfig instance with remote username, server address and path to private key.
-	ssh := &easyssh.MakeConfig{
-		Server:            host,
-		User:              p.Config.Username,
-		Password:          p.Config.Password,
-		Port:              port,
-		Protocol:          p.Config.Protocol,
-		Key:               p.Config.Key,
-		KeyPath:           p.Config.KeyPath,
-		Passphrase:        p.Config.Passphrase,
-		Timeout:           p.Config.Timeout,
-		Ciphers:           p.Config.Ciphers,
-		Fingerprint:       p.Config.Fingerprint,
-		UseInsecureCipher: p.Config.UseInsecureCipher,
-		RequestPty:        p.Config.RequireTty,
-		Proxy: easyssh.DefaultConfig{
-			Server:            p.Config.Proxy.Server,
-			User:              p.Config.Proxy.User,
-			Password:          p.Config.Proxy.Password,
-			Port:              p.Config.Proxy.Port,
-			Protocol:          p.Config.Proxy.Protocol,
-			Key:               p.Config.Proxy.Key,
-			KeyPath:           p.Config.Proxy.KeyPath,
-			Passphrase:        p.Config.Proxy.Passphrase,
-			Timeout:           p.Config.Proxy.Timeout,
-			Ciphers:           p.Config.Proxy.Ciphers,
-			Fingerprint:       p.Config.Proxy.Fingerprint,
-			UseInsecureCipher: p.Config.Proxy.UseInsecureCipher,
-		},
+// checkPort rejects ports that are not a number between 1 and 65535.
+func checkPort(port string) error {
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("port %q is not a number between 1 and 65535", port)
 	}
+	return nil
+}
 
+// isIPv6 reports whether s is an IPv6 address, with an optional zone as in
+// fe80::1%eth0.
+func isIPv6(s string) bool {
+	addr, err := netip.ParseAddr(s)
+	return err == nil && addr.Is6()
+}
+
+func (p Plugin) exec(t target) HostResult {
+	start := time.Now()
+	host := t.Alias
+	result := HostResult{
+		Host:    t.Name,
+		Address: t.address(),
+	}
 	if p.Config.Debug {
 		p.log(host, "======CMD======")
 		p.log(host, strings.Join(p.Config.Script, "\n"))
@@ -127,6 +273,12 @@ func (p Plugin) exec(host string, wg *sync.WaitGroup, errChannel chan error) {
 			)
 		}
 	}
+	for _, key := range slices.Sorted(maps.Keys(t.Envs)) {
+		env = append(
+			env,
+			p.format(p.Config.EnvsFormat, "{NAME}", key, "{VALUE}", escapeArg(t.Envs[key])),
+		)
+	}
 
 	if p.Config.Debug && len(env) > 0 {
 		p.log(host, "======ENV======")
@@ -137,13 +289,17 @@ func (p Plugin) exec(host string, wg *sync.WaitGroup, errChannel chan error) {
 	env = append(env, p.scriptCommands()...)
 	p.Config.Script = env
 
-	stdoutChan, stderrChan, doneChan, errChan, err := ssh.Stream(
-		strings.Join(p.Config.Script, "\n"),
-		p.Config.CommandTimeout,
+	stdoutChan, stderrChan, doneChan, errChan, err := p.streamWithRetry(
+		t.Name,
+		func() (<-chan string, <-chan string, <-chan bool, <-chan error, error) {
+			return p.stream(t, strings.Join(p.Config.Script, "\n"))
+		},
 	)
 	if err != nil {
-		errChannel <- err
-		return
+		result = newHostResult(result, err)
+		result.Status = StatusUnreachable
+		result.Duration = time.Since(start)
+		return result
 	}
 	// read from the output channel until the done signal is passed
 	var isTimeout bool
@@ -152,11 +308,21 @@ loop:
 		select {
 		case isTimeout = <-doneChan:
 			break loop
-		case outline := <-stdoutChan:
+		case outline, ok := <-stdoutChan:
+			if !ok {
+				stdoutChan = nil
+				continue
+			}
+			result.Stdout += int64(len(outline) + 1)
 			if outline != "" {
 				p.log(host, outline)
 			}
-		case errline := <-stderrChan:
+		case errline, ok := <-stderrChan:
+			if !ok {
+				stderrChan = nil
+				continue
+			}
+			result.Stderr += int64(len(errline) + 1)
 			if errline != "" {
 				p.log(host, errline)
 			}
@@ -164,15 +330,15 @@ loop:
 		}
 	}
 
-	// get exit code or command error.
-	if err != nil {
-		errChannel <- err
-	}
-
 	// command time out
 	if !isTimeout {
-		errChannel <- errCommandTimeOut
+		err = errCommandTimeOut
 	}
+
+	// get exit code or command error.
+	result = newHostResult(result, err)
+	result.Duration = time.Since(start)
+	return result
 }
 
 // format string
@@ -201,55 +367,144 @@ func (p Plugin) log(host string, message ...any) {
 
 // Exec executes the plugin.
 func (p Plugin) Exec() error {
-	p.Config.Host = trimValues(p.Config.Host)
+	_, err := p.Execute()
+	return err
+}
+
+// Execute executes the plugin and returns the result of every host.
+// The result is nil when the configuration is rejected before any dial.
+func (p Plugin) Execute() (*Result, error) {
+	listed, err := p.listedHosts()
+	if err != nil {
+		return nil, err
+	}
+	p.Config.Host = trimValues(slices.Concat(p.Config.Host, listed))
 
-	if len(p.Config.Host) == 0 {
-		return errMissingHost
+	hosts, err := expandHosts(p.Config.Host)
+	if err != nil {
+		return nil, err
+	}
+	if p.Config.Debug && (len(listed) > 0 || !slices.Equal(hosts, p.Config.Host)) {
+		w := p.getWriter()
+		fmt.Fprintln(w, "======HOSTS======")
+		fmt.Fprintln(w, strings.Join(hosts, "\n"))
+		fmt.Fprintln(w, "======END======")
 	}
+	p.Config.Host = hosts
 
-	if len(p.Config.Key) == 0 && len(p.Config.Password) == 0 && len(p.Config.KeyPath) == 0 {
-		return errMissingPasswordOrKey
+	if len(p.Config.Host) == 0 && strings.TrimSpace(p.Config.HostList) == "" &&
+		p.Config.Inventory == "" {
+		return nil, errMissingHost
+	}
+
+	if p.Config.UseAgent && os.Getenv("SSH_AUTH_SOCK") == "" {
+		return nil, errMissingAgent
+	}
+
+	// the entries of the host list, the inventory and the Host blocks of
+	// ssh_config may have their own credentials
+	if strings.TrimSpace(p.Config.HostList) == "" && p.Config.Inventory == "" &&
+		p.Config.SSHConfig == "" &&
+		len(p.Config.Key) == 0 && len(p.Config.Password) == 0 && len(p.Config.KeyPath) == 0 &&
+		len(trimValues(p.Config.Keys)) == 0 && len(trimValues(p.Config.KeyPaths)) == 0 &&
+		len(trimValues(p.Config.KeyboardInteractive)) == 0 && os.Getenv("SSH_AUTH_SOCK") == "" {
+		return nil, errMissingPasswordOrKey
 	}
 
 	if p.Config.EnvsFormat == "" {
 		p.Config.EnvsFormat = envsFormat
 	}
 
-	wg := sync.WaitGroup{}
-	wg.Add(len(p.Config.Host))
-	errChannel := make(chan error)
-	finished := make(chan struct{})
-	if p.Config.Sync {
-		go func() {
-			for _, host := range p.Config.Host {
-				p.exec(host, &wg, errChannel)
-			}
-		}()
-	} else {
-		for _, host := range p.Config.Host {
-			go p.exec(host, &wg, errChannel)
-		}
+	if p.Config.MaxParallel < 0 || p.Config.BatchSize < 0 || p.Config.BatchPause < 0 {
+		return nil, errInvalidRollout
 	}
 
-	go func() {
-		wg.Wait()
-		close(finished)
-	}()
+	targets, err := p.targets()
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, errMissingHost
+	}
 
-	select {
-	case <-finished:
-	case err := <-errChannel:
-		if err != nil {
-			return err
+	p.Config.Host = make([]string, 0, len(targets))
+	for _, t := range targets {
+		if !t.hasCredentials() {
+			return nil, fmt.Errorf("%w for %s", errMissingPasswordOrKey, t.Name)
 		}
+		p.Config.Host = append(p.Config.Host, t.Name)
+	}
+
+	canary, err := p.canaryHosts(targets)
+	if err != nil {
+		return nil, err
+	}
+
+	tol, err := p.tolerance()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Config.Failover && (len(canary) > 0 || p.Config.BatchSize > 0) {
+		return nil, errInvalidFailover
+	}
+
+	var result *Result
+	if p.Config.Failover {
+		result = p.failover(targets)
+	} else {
+		result = p.rollout(targets, canary, tol)
 	}
 
 	w := p.getWriter()
+	if p.Config.Summary {
+		fmt.Fprintln(w, "===============================================")
+		_ = result.WriteTable(w)
+	}
+
+	err = result.Err()
+	if p.Config.Failover {
+		host := result.succeeded()
+		if host == "" {
+			return result, err
+		}
+
+		fmt.Fprintln(w, "===============================================")
+		fmt.Fprintf(w, "✅ Successfully executed commands to %s.\n", host)
+		fmt.Fprintln(w, "===============================================")
+		return result, nil
+	}
+
+	if err != nil && !tol.allows(result.notOK(), len(result.Hosts)) {
+		return result, err
+	}
+
 	fmt.Fprintln(w, "===============================================")
-	fmt.Fprintln(w, "✅ Successfully executed commands to all hosts.")
+	if err != nil {
+		var multiErr *MultiHostError
+		errors.As(err, &multiErr)
+		fmt.Fprintf(
+			w,
+			"⚠️  %d of %d hosts failed, within the failure tolerance:\n",
+			result.notOK(),
+			len(result.Hosts),
+		)
+		for _, hostErr := range multiErr.Errors {
+			fmt.Fprintf(w, "  ❌ %s\n", hostErr)
+		}
+		fmt.Fprintln(w, "===============================================")
+		fmt.Fprintf(
+			w,
+			"✅ Successfully executed commands to %d of %d hosts.\n",
+			len(result.Hosts)-result.notOK(),
+			len(result.Hosts),
+		)
+	} else {
+		fmt.Fprintln(w, "✅ Successfully executed commands to all hosts.")
+	}
 	fmt.Fprintln(w, "===============================================")
 
-	return nil
+	return result, nil
 }
 
 func (p Plugin) scriptCommands() []string {
@@ -297,6 +552,31 @@ func trimValues(keys []string) []string {
 	return newKeys
 }
 
+// cappedBuffer keeps the first limit bytes written to it, the stderr of a
+// local command is cut short there. It is safe for the goroutine copying
+// the output and a reader.
+type cappedBuffer struct {
+	limit int
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if room := b.limit - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
 // Find all envs from specified prefix
 func findEnvs(prefix ...string) []string {
 	envs := []string{}
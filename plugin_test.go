@@ -3,8 +3,12 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
 	"io"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
@@ -16,6 +20,7 @@ import (
 	"github.com/testcontainers/testcontainers-go"
 	"github.com/testcontainers/testcontainers-go/wait"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
 func TestMissingHostOrUser(t *testing.T) {
@@ -188,6 +193,80 @@ func TestSSHScriptWithError(t *testing.T) {
 	assert.Error(t, err)
 }
 
+func TestSSHScriptWithErrorOnAllHosts(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"localhost", "127.0.0.1"},
+			Username:       "drone-scp",
+			Port:           22,
+			KeyPath:        "./tests/.ssh/id_rsa",
+			Script:         []string{"exit 1"},
+			CommandTimeout: 60 * time.Second,
+		},
+	}
+
+	err := plugin.Exec()
+	require.Error(t, err)
+
+	var multiErr *MultiHostError
+	require.ErrorAs(t, err, &multiErr)
+	require.Len(t, multiErr.Errors, 2)
+	assert.Equal(t, "localhost", multiErr.Errors[0].Host)
+	assert.Equal(t, "127.0.0.1", multiErr.Errors[1].Host)
+
+	var exitErr *ssh.ExitError
+	assert.ErrorAs(t, err, &exitErr)
+}
+
+func TestMultiHostError(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"127.0.0.1:1", "localhost:1"},
+			Username:       "drone-scp",
+			Port:           22,
+			Protocol:       easyssh.PROTOCOL_TCP,
+			Password:       "123456",
+			Script:         []string{"whoami"},
+			Timeout:        5 * time.Second,
+			CommandTimeout: 10 * time.Second,
+		},
+		Writer: io.Discard,
+	}
+
+	err := plugin.Exec()
+	require.Error(t, err)
+
+	var multiErr *MultiHostError
+	require.ErrorAs(t, err, &multiErr)
+	require.Len(t, multiErr.Errors, 2)
+	assert.Equal(t, "127.0.0.1:1", multiErr.Errors[0].Host)
+	assert.Equal(t, "localhost:1", multiErr.Errors[1].Host)
+	assert.Contains(t, err.Error(), "2 hosts failed")
+	assert.Contains(t, err.Error(), "127.0.0.1:1: ")
+	assert.Contains(t, err.Error(), "localhost:1: ")
+}
+
+func TestSSHCommandTimeOutOnAllHosts(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"localhost", "127.0.0.1"},
+			Username:       "drone-scp",
+			Port:           22,
+			KeyPath:        "./tests/.ssh/id_rsa",
+			Script:         []string{"sleep 5"},
+			CommandTimeout: 1 * time.Second,
+		},
+	}
+
+	err := plugin.Exec()
+	require.Error(t, err)
+
+	var multiErr *MultiHostError
+	require.ErrorAs(t, err, &multiErr)
+	assert.Len(t, multiErr.Errors, 2)
+	assert.ErrorIs(t, err, errCommandTimeOut)
+}
+
 func TestSSHCommandTimeOut(t *testing.T) {
 	plugin := Plugin{
 		Config: Config{
@@ -226,6 +305,58 @@ func TestProxyCommand(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestProxyJump(t *testing.T) {
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"localhost"},
+			Username:       "drone-scp",
+			Port:           22,
+			KeyPath:        "./tests/.ssh/id_rsa",
+			Script:         []string{"whoami"},
+			CommandTimeout: 10 * time.Second,
+			Proxy: easyssh.DefaultConfig{
+				User:    "drone-scp",
+				KeyPath: "./tests/.ssh/id_rsa",
+			},
+			ProxyJump: "localhost:22,drone-scp@127.0.0.1",
+		},
+		Writer: &buffer,
+	}
+
+	assert.NoError(t, plugin.Exec())
+	assert.Contains(t, buffer.String(), "drone-scp")
+
+	plugin.Config.ProxyJump = `[
+		{"host": "localhost", "user": "drone-scp", "key_path": "./tests/.ssh/id_rsa"},
+		{"host": "127.0.0.1", "port": 1, "user": "drone-scp", "key_path": "./tests/.ssh/id_rsa"}
+	]`
+	err := plugin.Exec()
+	assert.ErrorContains(t, err, "jump host 2 (127.0.0.1:1)")
+}
+
+func TestHostListEnvs(t *testing.T) {
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			Port:     22,
+			Protocol: easyssh.PROTOCOL_TCP,
+			HostList: `
+- host: drone-scp@localhost
+  key_path: ./tests/.ssh/id_rsa
+  envs:
+    role: primary
+`,
+			Script:         []string{"echo role=$ROLE"},
+			CommandTimeout: 10 * time.Second,
+		},
+		Writer: &buffer,
+	}
+
+	assert.NoError(t, plugin.Exec())
+	assert.Contains(t, buffer.String(), "role=primary")
+}
+
 func TestSSHCommandError(t *testing.T) {
 	plugin := Plugin{
 		Config: Config{
@@ -496,6 +627,111 @@ func TestFingerprint(t *testing.T) {
 	assert.Equal(t, unindent(expected), unindent(buffer.String()))
 }
 
+func TestKnownHosts(t *testing.T) {
+	hostKey, err := getHostPublicKeyFile("/etc/ssh/ssh_host_rsa_key.pub")
+	require.NoError(t, err)
+
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"localhost"},
+			Username:       "drone-scp",
+			Port:           22,
+			KeyPath:        "./tests/.ssh/id_rsa",
+			Script:         []string{"whoami"},
+			KnownHosts:     knownhosts.Line([]string{"localhost"}, hostKey),
+			CommandTimeout: 10 * time.Second,
+		},
+		Writer: io.Discard,
+	}
+
+	assert.NoError(t, plugin.Exec())
+
+	plugin.Config.KnownHosts = knownhosts.Line([]string{"example.com"}, hostKey)
+	assert.ErrorContains(t, plugin.Exec(), "strict_host_key_checking is yes")
+
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	plugin.Config.KnownHosts = ""
+	plugin.Config.KnownHostsPath = path
+	plugin.Config.StrictHostKeyChecking = strictHostKeyAcceptNew
+	require.NoError(t, plugin.Exec())
+
+	plugin.Config.StrictHostKeyChecking = strictHostKeyYes
+	assert.NoError(t, plugin.Exec())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "localhost ")
+}
+
+func TestSSHAgent(t *testing.T) {
+	key, err := ssh.ParseRawPrivateKey(mustReadFile(t, "./tests/.ssh/id_rsa"))
+	require.NoError(t, err)
+	startAgent(t, key)
+
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"localhost"},
+			Username:       "drone-scp",
+			Port:           22,
+			UseAgent:       true,
+			Script:         []string{"whoami"},
+			CommandTimeout: 10 * time.Second,
+		},
+		Writer: io.Discard,
+	}
+
+	assert.NoError(t, plugin.Exec())
+}
+
+func TestAgentForward(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"localhost"},
+			Username:       "drone-scp",
+			Port:           22,
+			KeyPath:        "./tests/.ssh/id_rsa",
+			AgentForward:   true,
+			Script:         []string{`test -S "$SSH_AUTH_SOCK"`},
+			CommandTimeout: 10 * time.Second,
+		},
+		Writer: io.Discard,
+	}
+
+	assert.NoError(t, plugin.Exec())
+
+	plugin.Config.KeyPath = ""
+	plugin.Config.Password = "1234"
+	assert.ErrorIs(t, plugin.Exec(), errAgentForward)
+}
+
+func TestMultipleKeys(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	unknown := filepath.Join(t.TempDir(), "id_unknown")
+	require.NoError(t, os.WriteFile(unknown, pem.EncodeToMemory(block), 0o600))
+
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"localhost"},
+			Username:       "drone-scp",
+			Port:           22,
+			KeyPaths:       []string{unknown, "./tests/.ssh/id_rsa"},
+			Password:       "wrong",
+			Script:         []string{"whoami"},
+			Debug:          true,
+			CommandTimeout: 10 * time.Second,
+		},
+		Writer: &buffer,
+	}
+
+	require.NoError(t, plugin.Exec())
+	assert.Contains(t, buffer.String(), "🔑 authenticated with ./tests/.ssh/id_rsa")
+}
+
 func TestScriptStopWithMultipleHostAndSyncMode(t *testing.T) {
 	var (
 		buffer   bytes.Buffer
@@ -793,78 +1029,25 @@ func TestUseInsecureCipher(t *testing.T) {
 	assert.Equal(t, unindent(expected), unindent(buffer.String()))
 }
 
-func TestPlugin_hostPort(t *testing.T) {
-	type fields struct {
-		Config Config
-		Writer io.Writer
-	}
-	type args struct {
-		h string
-	}
+func TestParseHostPort(t *testing.T) {
 	tests := []struct {
-		name     string
-		fields   fields
-		args     args
-		wantHost string
-		wantPort string
+		entry, host, port string
 	}{
-		{
-			name: "default host and port",
-			fields: fields{
-				Config: Config{
-					Port: 22,
-				},
-			},
-			args: args{
-				h: "localhost",
-			},
-			wantHost: "localhost",
-			wantPort: "22",
-		},
-		{
-			name: "different port",
-			fields: fields{
-				Config: Config{
-					Port:     22,
-					Protocol: easyssh.PROTOCOL_TCP4,
-				},
-			},
-			args: args{
-				h: "localhost:443",
-			},
-			wantHost: "localhost",
-			wantPort: "443",
-		},
-		{
-			name: "ipv6",
-			fields: fields{
-				Config: Config{
-					Port:     22,
-					Protocol: easyssh.PROTOCOL_TCP6,
-				},
-			},
-			args: args{
-				h: "::1",
-			},
-			wantHost: "::1",
-			wantPort: "22",
-		},
+		{"localhost", "localhost", ""},
+		{"localhost:443", "localhost", "443"},
+		{"::1", "::1", ""},
+		{"[2001:db8::1]:2222", "2001:db8::1", "2222"},
+		{"[::1]", "::1", ""},
+		{"localhost:2222", "localhost", "2222"},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			p := Plugin{
-				Config: tt.fields.Config,
-				Writer: tt.fields.Writer,
-			}
-			gotHost, gotPort := p.hostPort(tt.args.h)
-			if gotHost != tt.wantHost {
-				t.Errorf("Plugin.hostPort() gotHost = %v, want %v", gotHost, tt.wantHost)
-			}
-			if gotPort != tt.wantPort {
-				t.Errorf("Plugin.hostPort() gotPort = %v, want %v", gotPort, tt.wantPort)
-			}
-		})
+		host, port, err := parseHostPort(tt.entry)
+		require.NoError(t, err, tt.entry)
+		assert.Equal(t, []string{tt.host, tt.port}, []string{host, port}, tt.entry)
 	}
+
+	_, _, err := parseHostPort("[::1]x")
+	assert.EqualError(t, err, `unexpected "x" after ]`)
 }
 
 func TestFindEnvs(t *testing.T) {
@@ -1100,3 +1283,27 @@ func TestCommandWithIPv6(t *testing.T) {
 	require.NoError(t, plugin.Exec())
 	assert.Equal(t, unindent(expected), unindent(buffer.String()))
 }
+
+func TestInventoryEnvs(t *testing.T) {
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			Port:     22,
+			Protocol: easyssh.PROTOCOL_TCP,
+			Inventory: writeInventory(t, "hosts", `
+[app]
+app1 ansible_host=localhost ansible_user=drone-scp ansible_ssh_private_key_file=./tests/.ssh/id_rsa
+
+[app:vars]
+role=primary
+`),
+			Groups:         []string{"app"},
+			Script:         []string{"echo role=$ROLE"},
+			CommandTimeout: 10 * time.Second,
+		},
+		Writer: &buffer,
+	}
+
+	assert.NoError(t, plugin.Exec())
+	assert.Contains(t, buffer.String(), "role=primary")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxProxyCommandStderr bounds the stderr of the proxy command kept for
+// the error message.
+const maxProxyCommandStderr = 4096
+
+// checkProxyCommand rejects the % tokens of Config.ProxyCommand other than
+// %h, %p, %r and %%.
+func checkProxyCommand(command string) error {
+	for i := 0; i < len(command); i++ {
+		if command[i] != '%' {
+			continue
+		}
+		if i+1 == len(command) || !strings.ContainsRune("hpr%", rune(command[i+1])) {
+			return fmt.Errorf(
+				"error: proxy_command %q has an unknown token, use %%h, %%p, %%r or %%%%",
+				command,
+			)
+		}
+		i++
+	}
+	return nil
+}
+
+// shellMetacharacters may not appear in the host and user substituted
+// into Config.ProxyCommand, as in OpenSSH, sh would run what follows them.
+const shellMetacharacters = "'`\"$\\;&<>|(){}*?[]~#!\n\r\t "
+
+// expandProxyCommand substitutes the host, port and user of e, the host
+// is the resolved address under Config.ResolveAll. A host or user with
+// shell metacharacters or a leading - is rejected.
+func expandProxyCommand(command string, e endpoint) (string, error) {
+	host := e.Host
+	if e.IP != "" {
+		host = e.IP
+	}
+	for _, value := range []struct{ name, value string }{{"host", host}, {"user", e.User}} {
+		if strings.ContainsAny(value.value, shellMetacharacters) || strings.HasPrefix(value.value, "-") {
+			return "", fmt.Errorf(
+				"error: proxy_command: the %s %q has shell metacharacters", value.name, value.value,
+			)
+		}
+	}
+	return strings.NewReplacer(
+		"%%", "%",
+		"%h", host,
+		"%p", e.Port,
+		"%r", e.User,
+	).Replace(command), nil
+}
+
+// dialCommand starts the proxy command of e with sh, its stdin and stdout
+// carry the connection. The process is killed when the connection is
+// closed.
+func dialCommand(command string, e endpoint) (*commandConn, error) {
+	command, err := expandProxyCommand(command, e)
+	if err != nil {
+		return nil, err
+	}
+
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		stdinReader.Close()
+		stdinWriter.Close()
+		return nil, err
+	}
+
+	c := &commandConn{
+		cmd:     exec.Command("sh", "-c", command),
+		address: e.address(),
+		stdin:   stdinWriter,
+		stdout:  stdoutReader,
+		done:    make(chan struct{}),
+	}
+	c.cmd.Stdin = stdinReader
+	c.cmd.Stdout = stdoutWriter
+	c.stderr.limit = maxProxyCommandStderr
+	c.cmd.Stderr = &c.stderr
+	c.cmd.WaitDelay = time.Second
+	setProcessGroup(c.cmd)
+
+	err = c.cmd.Start()
+	// the child keeps its own copies of these ends
+	stdinReader.Close()
+	stdoutWriter.Close()
+	if err != nil {
+		stdinWriter.Close()
+		stdoutReader.Close()
+		return nil, fmt.Errorf("error: can't start proxy_command %q: %w", command, err)
+	}
+
+	go func() {
+		c.err = c.cmd.Wait()
+		close(c.done)
+	}()
+
+	return c, nil
+}
+
+// commandConn is a net.Conn over the stdin and stdout of a proxy command.
+type commandConn struct {
+	cmd     *exec.Cmd
+	address string
+	stdin   *os.File
+	stdout  *os.File
+	stderr  cappedBuffer
+
+	done      chan struct{}
+	err       error
+	closeOnce sync.Once
+}
+
+func (c *commandConn) Read(b []byte) (int, error) {
+	n, err := c.stdout.Read(b)
+	if errors.Is(err, io.EOF) {
+		// tell why the command ended before the connection did
+		select {
+		case <-c.done:
+			if c.err != nil {
+				return n, c.exitError()
+			}
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return n, err
+}
+
+func (c *commandConn) Write(b []byte) (int, error) {
+	n, err := c.stdin.Write(b)
+	if err != nil {
+		select {
+		case <-c.done:
+			if c.err != nil {
+				return n, c.exitError()
+			}
+		default:
+		}
+	}
+	return n, err
+}
+
+// exitError reports the exit status and stderr of the ended command.
+func (c *commandConn) exitError() error {
+	stderr := strings.TrimSpace(c.stderr.String())
+	if stderr == "" {
+		return fmt.Errorf("proxy_command: %w", c.err)
+	}
+	return fmt.Errorf("proxy_command: %w: %s", c.err, stderr)
+}
+
+// Close ends the connection and kills the proxy command with its process
+// group, a pipeline or a wrapper may leave the real proxy running after sh.
+func (c *commandConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.stdin.Close()
+		select {
+		case <-c.done:
+		case <-time.After(100 * time.Millisecond):
+		}
+		_ = killProcessGroup(c.cmd)
+		<-c.done
+		c.stdout.Close()
+	})
+	return nil
+}
+
+func (c *commandConn) LocalAddr() net.Addr {
+	return commandAddr("proxy_command")
+}
+
+// RemoteAddr is the address the command connects to, known_hosts matches it.
+func (c *commandConn) RemoteAddr() net.Addr {
+	return commandAddr(c.address)
+}
+
+func (c *commandConn) SetDeadline(t time.Time) error {
+	return errors.Join(c.stdin.SetDeadline(t), c.stdout.SetDeadline(t))
+}
+
+func (c *commandConn) SetReadDeadline(t time.Time) error {
+	return c.stdout.SetReadDeadline(t)
+}
+
+func (c *commandConn) SetWriteDeadline(t time.Time) error {
+	return c.stdin.SetWriteDeadline(t)
+}
+
+// commandAddr is the net.Addr of a proxy command connection.
+type commandAddr string
+
+func (a commandAddr) Network() string {
+	return "proxy_command"
+}
+
+func (a commandAddr) String() string {
+	return string(a)
+}
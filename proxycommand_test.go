@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckProxyCommand(t *testing.T) {
+	assert.NoError(t, checkProxyCommand(""))
+	assert.NoError(t, checkProxyCommand("cloudflared access ssh --hostname %h"))
+	assert.NoError(t, checkProxyCommand("nc -X 5 -x proxy:1080 %h %p # 100%%"))
+
+	assert.ErrorContains(t, checkProxyCommand("nc %h %d"), "has an unknown token")
+	assert.ErrorContains(t, checkProxyCommand("nc %h %"), "has an unknown token")
+}
+
+func TestExpandProxyCommand(t *testing.T) {
+	e := endpoint{Host: "db.internal", Port: "2222", User: "deploy"}
+	command, err := expandProxyCommand("ssh -W %h:%p %r@bastion # 100%%h", e)
+	require.NoError(t, err)
+	assert.Equal(t, "ssh -W db.internal:2222 deploy@bastion # 100%h", command)
+
+	e = endpoint{Host: "fe80::1%eth0", Port: "22", User: "deploy.user-1"}
+	command, err = expandProxyCommand("nc %h %p", e)
+	require.NoError(t, err)
+	assert.Equal(t, "nc fe80::1%eth0 22", command)
+
+	for _, e := range []endpoint{
+		{Host: "a;touch${IFS}/tmp/x;b", Port: "22"},
+		{Host: "$(id)", Port: "22"},
+		{Host: "-oProxyCommand=id", Port: "22"},
+		{Host: "db", Port: "22", User: "deploy`id`"},
+		{Host: "db", Port: "22", User: "a b"},
+	} {
+		_, err = expandProxyCommand("true %h %r", e)
+		assert.ErrorContains(t, err, "has shell metacharacters", e.Host+" "+e.User)
+	}
+}
+
+func TestDialCommandHostileHost(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "x")
+	_, err := dialCommand("true %h", endpoint{Host: "a;touch${IFS}" + marker + ";b", Port: "22"})
+	assert.ErrorContains(t, err, "has shell metacharacters")
+	assert.NoFileExists(t, marker)
+}
+
+func TestDialCommand(t *testing.T) {
+	e := endpoint{Host: "db.internal", Port: "2222"}
+	conn, err := dialCommand(`printf 'SSH-2.0-%h\r\n'; cat`, e)
+	require.NoError(t, err)
+	assert.Equal(t, "db.internal:2222", conn.RemoteAddr().String())
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "SSH-2.0-db.internal\r\n", line)
+
+	_, err = conn.Write([]byte("echo\n"))
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, conn.Close())
+	assert.Less(t, time.Since(start), 2*time.Second)
+	select {
+	case <-conn.done:
+	default:
+		t.Fatal("the proxy command is still running")
+	}
+}
+
+func TestDialCommandKilled(t *testing.T) {
+	conn, err := dialCommand("sleep 60", endpoint{Host: "db.internal", Port: "22"})
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, conn.Close())
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestDialCommandExit(t *testing.T) {
+	conn, err := dialCommand("echo 'no route to %h' >&2; exit 3", endpoint{Host: "db.internal", Port: "22"})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = io.ReadAll(conn)
+	assert.EqualError(t, err, "proxy_command: exit status 3: no route to db.internal")
+}
+
+func TestProxyCommandWithNetworkProxy(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:         []string{"web"},
+			Password:     "1234",
+			NetworkProxy: "socks5://127.0.0.1:1080",
+			ProxyCommand: "nc %h %p",
+		},
+	}
+
+	_, err := plugin.Execute()
+	assert.ErrorIs(t, err, errProxyCommandWithNetworkProxy)
+}
+
+func TestProxyCommandHandshakeTimeout(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:         []string{"web"},
+			Password:     "1234",
+			Port:         22,
+			Timeout:      500 * time.Millisecond,
+			ProxyCommand: "sleep 60",
+			Script:       []string{"whoami"},
+		},
+	}
+
+	start := time.Now()
+	_, err := plugin.Execute()
+	assert.ErrorContains(t, err, "proxy_command: no SSH handshake within 500ms")
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
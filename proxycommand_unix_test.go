@@ -0,0 +1,46 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// running reports whether pid is alive, a zombie waiting for its parent
+// is not.
+func running(pid int) bool {
+	if stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat"); err == nil {
+		fields := strings.Fields(string(stat[strings.LastIndex(string(stat), ")")+1:]))
+		return len(fields) > 0 && fields[0] != "Z"
+	}
+	return syscall.Kill(pid, 0) == nil
+}
+
+func TestDialCommandKillsProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	conn, err := dialCommand(
+		"sleep 60 & echo $! > "+pidFile+"; sleep 60 | cat",
+		endpoint{Host: "db.internal", Port: "22"},
+	)
+	require.NoError(t, err)
+
+	var pid int
+	require.Eventually(t, func() bool {
+		data, _ := os.ReadFile(pidFile)
+		pid, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	require.True(t, running(pid))
+
+	require.NoError(t, conn.Close())
+	assert.Eventually(t, func() bool { return !running(pid) }, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,11 @@
+package main
+
+import "os/exec"
+
+// setProcessGroup is a no-op, Windows has no process groups to kill.
+func setProcessGroup(*exec.Cmd) {}
+
+// killProcessGroup kills the proxy command itself.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	easyssh "github.com/appleboy/easyssh-proxy"
+)
+
+// jumpHop is a hop of the list form of Config.ProxyJump, each hop has its
+// own credentials and fingerprint.
+type jumpHop struct {
+	Host        string      `json:"host"`
+	Port        json.Number `json:"port"`
+	User        string      `json:"user"`
+	Username    string      `json:"username"`
+	Password    string      `json:"password"`
+	Key         string      `json:"key"`
+	KeyPath     string      `json:"key_path"`
+	Passphrase  string      `json:"passphrase"`
+	Fingerprint string      `json:"fingerprint"`
+}
+
+// parseProxyJump returns the hops of Config.ProxyJump, the bastion closest
+// to the runner first. It is either [user@]host[:port] entries separated by
+// commas, as the OpenSSH ProxyJump, which use the credentials of the proxy
+// settings, or a JSON list of hops with their own credentials. The proxy
+// fingerprint verifies a single hop, a chain needs the JSON list for it. Every hop
+// shares the protocol, timeout and ciphers of the proxy settings.
+func parseProxyJump(value string, proxy easyssh.DefaultConfig) ([]easyssh.DefaultConfig, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(value, "[") && json.Valid([]byte(value)) {
+		return parseJumpHops(value, proxy)
+	}
+
+	jumps := strings.Split(value, ",")
+	if len(jumps) > 1 && proxy.Fingerprint != "" {
+		return nil, errProxyJumpFingerprint
+	}
+
+	var hops []easyssh.DefaultConfig
+	for _, jump := range jumps {
+		user, host, port, err := splitUserHostPort(strings.TrimSpace(jump))
+		if err != nil {
+			return nil, fmt.Errorf("error: invalid proxy_jump hop %q: %w", jump, err)
+		}
+
+		hop := proxy
+		hop.Server = host
+		hop.Port = port
+		if user != "" {
+			hop.User = user
+		}
+		hops = append(hops, jumpDefaults(hop))
+	}
+
+	return hops, nil
+}
+
+// parseJumpHops reads the list form of Config.ProxyJump.
+func parseJumpHops(value string, proxy easyssh.DefaultConfig) ([]easyssh.DefaultConfig, error) {
+	var entries []jumpHop
+	if err := json.Unmarshal([]byte(value), &entries); err != nil {
+		return nil, fmt.Errorf("error: can't parse proxy_jump: %w", err)
+	}
+
+	hops := make([]easyssh.DefaultConfig, 0, len(entries))
+	for i, entry := range entries {
+		if strings.TrimSpace(entry.Host) == "" {
+			return nil, fmt.Errorf("error: proxy_jump hop %d has no host", i+1)
+		}
+		user, host, port, err := splitUserHostPort(strings.TrimSpace(entry.Host))
+		if err != nil {
+			return nil, fmt.Errorf("error: invalid proxy_jump hop %d: %w", i+1, err)
+		}
+
+		if entry.Port != "" {
+			if err := checkPort(entry.Port.String()); err != nil {
+				return nil, fmt.Errorf("error: invalid port of proxy_jump hop %d: %w", i+1, err)
+			}
+			port = entry.Port.String()
+		}
+		if entry.User != "" {
+			user = entry.User
+		}
+		if entry.Username != "" {
+			user = entry.Username
+		}
+
+		hops = append(hops, jumpDefaults(easyssh.DefaultConfig{
+			Server:            host,
+			Port:              port,
+			User:              user,
+			Password:          entry.Password,
+			Key:               entry.Key,
+			KeyPath:           entry.KeyPath,
+			Passphrase:        entry.Passphrase,
+			Fingerprint:       strings.TrimSpace(entry.Fingerprint),
+			Protocol:          proxy.Protocol,
+			Timeout:           proxy.Timeout,
+			Ciphers:           proxy.Ciphers,
+			UseInsecureCipher: proxy.UseInsecureCipher,
+		}))
+	}
+
+	return hops, nil
+}
+
+// jumpDefaults fills the user and port a hop leaves out.
+func jumpDefaults(hop easyssh.DefaultConfig) easyssh.DefaultConfig {
+	if hop.User == "" {
+		hop.User = defaultUsername
+	}
+	if hop.Port == "" {
+		hop.Port = "22"
+	}
+	return hop
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	easyssh "github.com/appleboy/easyssh-proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProxyJump(t *testing.T) {
+	proxy := easyssh.DefaultConfig{
+		User:        "ubuntu",
+		Password:    "1234",
+		KeyPath:     "./tests/.ssh/id_rsa",
+		Fingerprint: "SHA256:proxy",
+		Timeout:     5 * time.Second,
+	}
+
+	hops, err := parseProxyJump("", proxy)
+	require.NoError(t, err)
+	assert.Empty(t, hops)
+
+	// a single hop is verified with the proxy fingerprint
+	hops, err = parseProxyJump("deploy@b1:22", proxy)
+	require.NoError(t, err)
+	require.Len(t, hops, 1)
+	assert.Equal(t, "SHA256:proxy", hops[0].Fingerprint)
+
+	_, err = parseProxyJump("b1,b2", proxy)
+	assert.ErrorIs(t, err, errProxyJumpFingerprint)
+
+	proxy.Fingerprint = ""
+	hops, err = parseProxyJump("deploy@b1:22, b2:2222 ,[2001:db8::1]", proxy)
+	require.NoError(t, err)
+	require.Len(t, hops, 3)
+	assert.Equal(t, "b1", hops[0].Server)
+	assert.Equal(t, "deploy", hops[0].User)
+	assert.Equal(t, "b2", hops[1].Server)
+	assert.Equal(t, "2222", hops[1].Port)
+	assert.Equal(t, "ubuntu", hops[1].User)
+	assert.Equal(t, "1234", hops[1].Password)
+	assert.Equal(t, "./tests/.ssh/id_rsa", hops[1].KeyPath)
+	assert.Empty(t, hops[1].Fingerprint)
+	assert.Equal(t, "2001:db8::1", hops[2].Server)
+	assert.Equal(t, "22", hops[2].Port)
+
+	_, err = parseProxyJump("b1,b2:ssh", proxy)
+	assert.ErrorContains(t, err, `invalid proxy_jump hop "b2:ssh"`)
+
+	_, err = parseProxyJump("b1,,b2", proxy)
+	assert.ErrorContains(t, err, "missing host")
+
+	// hops are parsed as host entries: the last @ splits the user and a
+	// bare IPv6 address has no port
+	hops, err = parseProxyJump("a@b@c,u@2001:db8::1:2222", proxy)
+	require.NoError(t, err)
+	assert.Equal(t, "a@b", hops[0].User)
+	assert.Equal(t, "c", hops[0].Server)
+	assert.Equal(t, "2001:db8::1:2222", hops[1].Server)
+	assert.Equal(t, "22", hops[1].Port)
+
+	for jump, want := range map[string]string{
+		"b1:70000": `port "70000" is not a number between 1 and 65535`,
+		"b1:-5":    `port "-5" is not a number between 1 and 65535`,
+		"b1:22:33": "too many colons",
+		"[::1]x":   `unexpected "x" after ]`,
+		"@b1":      "missing user before @",
+	} {
+		_, err = parseProxyJump(jump, proxy)
+		assert.ErrorContains(t, err, want, jump)
+	}
+}
+
+func TestParseProxyJumpList(t *testing.T) {
+	proxy := easyssh.DefaultConfig{
+		Password: "shared",
+		Timeout:  5 * time.Second,
+		Ciphers:  []string{"aes256-ctr"},
+	}
+
+	hops, err := parseProxyJump(`[
+		{"host": "b1", "port": 2200, "user": "jump", "key_path": "/keys/b1", "fingerprint": "SHA256:b1"},
+		{"host": "b2:2222", "username": "deploy", "password": "secret"},
+		{"host": "b3", "port": "22"}
+	]`, proxy)
+	require.NoError(t, err)
+	require.Len(t, hops, 3)
+
+	assert.Equal(t, easyssh.DefaultConfig{
+		Server:      "b1",
+		Port:        "2200",
+		User:        "jump",
+		KeyPath:     "/keys/b1",
+		Fingerprint: "SHA256:b1",
+		Timeout:     5 * time.Second,
+		Ciphers:     []string{"aes256-ctr"},
+	}, hops[0])
+	assert.Equal(t, "2222", hops[1].Port)
+	assert.Equal(t, "deploy", hops[1].User)
+	assert.Equal(t, "secret", hops[1].Password)
+	assert.Equal(t, "root", hops[2].User)
+	assert.Empty(t, hops[2].Password)
+
+	_, err = parseProxyJump(`[{"port": 22}]`, proxy)
+	assert.ErrorContains(t, err, "proxy_jump hop 1 has no host")
+
+	_, err = parseProxyJump(`[{"host": "b1", "port": 2.5}]`, proxy)
+	assert.ErrorContains(t, err, `invalid port of proxy_jump hop 1: port "2.5"`)
+
+	_, err = parseProxyJump(`[{"host": "b1", "port": 70000}]`, proxy)
+	assert.ErrorContains(t, err, `invalid port of proxy_jump hop 1: port "70000"`)
+
+	_, err = parseProxyJump(`[{"host": "b1:-5"}]`, proxy)
+	assert.ErrorContains(t, err, "invalid proxy_jump hop 1")
+
+	_, err = parseProxyJump(`[{"host": ["b1"]}]`, proxy)
+	assert.ErrorContains(t, err, "can't parse proxy_jump")
+}
+
+func TestProxyJumpWithProxyHost(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:      []string{"web"},
+			Password:  "1234",
+			Proxy:     easyssh.DefaultConfig{Server: "bastion"},
+			ProxyJump: "b1,b2",
+		},
+	}
+
+	_, err := plugin.Execute()
+	assert.ErrorIs(t, err, errProxyJumpWithProxy)
+}
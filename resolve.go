@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+
+	easyssh "github.com/appleboy/easyssh-proxy"
+)
+
+// lookupNetIP resolves the hosts of Config.ResolveAll, tests replace it.
+var lookupNetIP = net.DefaultResolver.LookupNetIP
+
+// resolveAll returns a target for every address of t, named name(ip).
+// The addresses follow Config.Protocol: A records for tcp4, AAAA records
+// for tcp6 and both for tcp. Host keys are still verified for the name.
+func (p Plugin) resolveAll(t target) ([]target, error) {
+	if _, err := netip.ParseAddr(t.Host); err == nil {
+		return []target{t}, nil
+	}
+
+	network := "ip"
+	switch p.Config.Protocol {
+	case easyssh.PROTOCOL_TCP4:
+		network = "ip4"
+	case easyssh.PROTOCOL_TCP6:
+		network = "ip6"
+	}
+
+	timeout := p.Config.Timeout
+	if timeout == 0 {
+		timeout = defaultDialTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	addrs, err := lookupNetIP(ctx, network, t.Host)
+	if err != nil {
+		return nil, fmt.Errorf("error: can't resolve %s: %w", t.Host, err)
+	}
+
+	var ips []string
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		if network == "ip4" && !addr.Is4() || network == "ip6" && !addr.Is6() {
+			continue
+		}
+		if ip := addr.String(); !slices.Contains(ips, ip) {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("error: %s has no %s addresses", t.Host, p.Config.Protocol)
+	}
+
+	targets := make([]target, 0, len(ips))
+	for _, ip := range ips {
+		resolved := t
+		resolved.IP = ip
+		resolved.Name = fmt.Sprintf("%s(%s)", t.Name, ip)
+		resolved.Alias = fmt.Sprintf("%s(%s)", t.Alias, ip)
+		targets = append(targets, resolved)
+	}
+	return targets, nil
+}
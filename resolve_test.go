@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	easyssh "github.com/appleboy/easyssh-proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeLookup answers every name with addrs for the length of the test.
+func fakeLookup(t *testing.T, addrs ...string) *[]string {
+	t.Helper()
+	var networks []string
+	lookup := lookupNetIP
+	t.Cleanup(func() { lookupNetIP = lookup })
+
+	lookupNetIP = func(_ context.Context, network, host string) ([]netip.Addr, error) {
+		networks = append(networks, network)
+		if len(addrs) == 0 {
+			return nil, errors.New("no such host")
+		}
+		var result []netip.Addr
+		for _, addr := range addrs {
+			result = append(result, netip.MustParseAddr(addr))
+		}
+		return result, nil
+	}
+	return &networks
+}
+
+func TestResolveAll(t *testing.T) {
+	networks := fakeLookup(t, "10.0.0.1", "2001:db8::1", "::ffff:10.0.0.2", "10.0.0.1")
+
+	p := Plugin{Config: Config{Port: 22, Protocol: easyssh.PROTOCOL_TCP}}
+	targets, err := p.resolveAll(target{Name: "deploy@app.test:2222", Alias: "app.test", Host: "app.test", Port: "2222"})
+	require.NoError(t, err)
+
+	var names, addresses []string
+	for _, target := range targets {
+		names = append(names, target.Name)
+		addresses = append(addresses, target.address())
+		assert.Equal(t, "app.test", target.Host)
+		assert.Equal(t, "app.test:2222", target.endpoint(p.Config).address())
+	}
+	assert.Equal(t, []string{
+		"deploy@app.test:2222(10.0.0.1)",
+		"deploy@app.test:2222(2001:db8::1)",
+		"deploy@app.test:2222(10.0.0.2)",
+	}, names)
+	assert.Equal(t, []string{"10.0.0.1:2222", "[2001:db8::1]:2222", "10.0.0.2:2222"}, addresses)
+	assert.Equal(t, "app.test(2001:db8::1)", targets[1].Alias)
+
+	p.Config.Protocol = easyssh.PROTOCOL_TCP4
+	targets, err = p.resolveAll(target{Name: "app.test", Host: "app.test"})
+	require.NoError(t, err)
+	assert.Len(t, targets, 2)
+
+	p.Config.Protocol = easyssh.PROTOCOL_TCP6
+	targets, err = p.resolveAll(target{Name: "app.test", Host: "app.test"})
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "2001:db8::1", targets[0].IP)
+	assert.Equal(t, []string{"ip", "ip4", "ip6"}, *networks)
+
+	// addresses are not looked up
+	targets, err = p.resolveAll(target{Name: "10.0.0.9", Host: "10.0.0.9"})
+	require.NoError(t, err)
+	assert.Equal(t, []target{{Name: "10.0.0.9", Host: "10.0.0.9"}}, targets)
+	assert.Len(t, *networks, 3)
+}
+
+func TestResolveAllErrors(t *testing.T) {
+	fakeLookup(t, "10.0.0.1")
+	p := Plugin{Config: Config{Protocol: easyssh.PROTOCOL_TCP6}}
+	_, err := p.resolveAll(target{Host: "app.test"})
+	assert.EqualError(t, err, "error: app.test has no tcp6 addresses")
+
+	fakeLookup(t)
+	_, err = p.resolveAll(target{Host: "app.test"})
+	assert.EqualError(t, err, "error: can't resolve app.test: no such host")
+}
+
+func TestResolveAllExecute(t *testing.T) {
+	fakeLookup(t, "127.0.0.1", "::1")
+	path := filepath.Join(t.TempDir(), "known_hosts")
+
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			Host:                  []string{"app.test"},
+			Username:              "drone-scp",
+			Port:                  22,
+			Protocol:              easyssh.PROTOCOL_TCP,
+			KeyPath:               "./tests/.ssh/id_rsa",
+			KnownHostsPath:        path,
+			StrictHostKeyChecking: "accept-new",
+			ResolveAll:            true,
+			Script:                []string{"echo ok"},
+			CommandTimeout:        10 * time.Second,
+		},
+		Writer: &buffer,
+	}
+
+	result, err := plugin.Execute()
+	require.NoError(t, err)
+	require.Len(t, result.Hosts, 2)
+	assert.Equal(t, "app.test(127.0.0.1)", result.Hosts[0].Host)
+	assert.Equal(t, "127.0.0.1:22", result.Hosts[0].Address)
+	assert.Equal(t, "app.test(::1)", result.Hosts[1].Host)
+	assert.Contains(t, buffer.String(), "app.test(127.0.0.1): ok")
+	assert.Contains(t, buffer.String(), "app.test(::1): ok")
+
+	// the host key is learned for the name, not the addresses
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(data), "app.test "), string(data))
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+}
+
+func TestResolveAllCanary(t *testing.T) {
+	fakeLookup(t, "127.0.0.1", "::1")
+
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"app.test", "127.0.0.1:1"},
+			Username:       "drone-scp",
+			Port:           22,
+			Protocol:       easyssh.PROTOCOL_TCP,
+			KeyPath:        "./tests/.ssh/id_rsa",
+			Fingerprints:   []string{"app.test=" + ssh.FingerprintSHA256(newHostKey(t))},
+			ResolveAll:     true,
+			CanaryHosts:    []string{"app.test"},
+			Script:         []string{"echo ok"},
+			Timeout:        5 * time.Second,
+			CommandTimeout: 10 * time.Second,
+		},
+		Writer: &buffer,
+	}
+
+	// the fingerprint keyed by the name applies to every address, and
+	// both addresses run, and fail, as canaries
+	result, err := plugin.Execute()
+	require.Error(t, err)
+	require.Len(t, result.Hosts, 3)
+	for _, host := range result.Hosts[:2] {
+		assert.Equal(t, stageCanary, host.Stage, host.Host)
+		assert.ErrorContains(t, host.Err, "fingerprint", host.Host)
+	}
+	assert.Equal(t, StatusSkipped, result.Hosts[2].Status)
+	assert.Contains(t, buffer.String(), "canary: app.test(127.0.0.1), app.test(::1)")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+
+	easyssh "github.com/appleboy/easyssh-proxy"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultRetryBackoff    = time.Second
+	defaultRetryMaxBackoff = 30 * time.Second
+)
+
+// streamFunc starts the command on the remote host, see easyssh.MakeConfig.Stream.
+type streamFunc func() (<-chan string, <-chan string, <-chan bool, <-chan error, error)
+
+// streamWithRetry starts the command, retrying transient connection and
+// handshake failures up to Config.ConnectRetries times. Once the command
+// has started it is never retried.
+func (p Plugin) streamWithRetry(
+	host string,
+	stream streamFunc,
+) (<-chan string, <-chan string, <-chan bool, <-chan error, error) {
+	attempts := p.Config.ConnectRetries + 1
+	for attempt := 1; ; attempt++ {
+		stdoutChan, stderrChan, doneChan, errChan, err := stream()
+		if err == nil || attempt >= attempts || !isTransient(err) {
+			return stdoutChan, stderrChan, doneChan, errChan, err
+		}
+
+		wait := p.retryBackoff(attempt)
+		p.notice(
+			"🔌 %s: connection attempt %d/%d failed: %v, retrying in %s",
+			host,
+			attempt,
+			attempts,
+			err,
+			wait,
+		)
+		time.Sleep(wait)
+	}
+}
+
+// retryBackoff returns the pause after the given failed attempt,
+// doubling from Config.ConnectRetryBackoff up to Config.ConnectRetryMaxBackoff.
+func (p Plugin) retryBackoff(attempt int) time.Duration {
+	backoff := p.Config.ConnectRetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	maxBackoff := p.Config.ConnectRetryMaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, maxBackoff)
+}
+
+// isTransient reports whether a connection error is worth another attempt:
+// timeouts, refused or reset connections, also behind a jump host, and
+// handshakes cut short.
+// Authentication and host key failures are final.
+func isTransient(err error) bool {
+	if errors.Is(err, easyssh.ErrProxyDialTimeout) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+
+	// a jump host reports a refused connection to the next hop as a
+	// failed channel
+	var channelErr *ssh.OpenChannelError
+	if errors.As(err, &channelErr) && channelErr.Reason == ssh.ConnectionFailed {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/appleboy/easyssh-proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{
+			name: "connection refused",
+			err:  &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED},
+			want: true,
+		},
+		{
+			name: "handshake reset",
+			err:  fmt.Errorf("ssh: handshake failed: %w", io.EOF),
+			want: true,
+		},
+		{
+			name: "proxy dial timeout",
+			err:  fmt.Errorf("%w: context deadline exceeded", easyssh.ErrProxyDialTimeout),
+			want: true,
+		},
+		{
+			name: "dial timeout",
+			err:  &net.OpError{Op: "dial", Net: "tcp", Err: timeoutError{}},
+			want: true,
+		},
+		{
+			name: "refused behind a jump host",
+			err: fmt.Errorf(
+				"ssh: rejected: %w",
+				&ssh.OpenChannelError{Reason: ssh.ConnectionFailed, Message: "Connection refused"},
+			),
+			want: true,
+		},
+		{
+			name: "forwarding prohibited by the jump host",
+			err:  &ssh.OpenChannelError{Reason: ssh.Prohibited, Message: "administratively prohibited"},
+			want: false,
+		},
+		{
+			name: "authentication failure",
+			err:  errors.New("ssh: handshake failed: ssh: unable to authenticate"),
+			want: false,
+		},
+		{
+			name: "unknown host",
+			err:  &net.DNSError{Err: "no such host", Name: "foo.invalid", IsNotFound: true},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isTransient(tt.err))
+		})
+	}
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestRetryBackoff(t *testing.T) {
+	p := Plugin{
+		Config: Config{
+			ConnectRetryBackoff:    time.Second,
+			ConnectRetryMaxBackoff: 5 * time.Second,
+		},
+	}
+
+	assert.Equal(t, 1*time.Second, p.retryBackoff(1))
+	assert.Equal(t, 2*time.Second, p.retryBackoff(2))
+	assert.Equal(t, 4*time.Second, p.retryBackoff(3))
+	assert.Equal(t, 5*time.Second, p.retryBackoff(4))
+	assert.Equal(t, 5*time.Second, p.retryBackoff(10))
+
+	p = Plugin{}
+	assert.Equal(t, defaultRetryBackoff, p.retryBackoff(1))
+}
+
+func TestConnectRetries(t *testing.T) {
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			Host:                []string{"127.0.0.1:1"},
+			Username:            "drone-scp",
+			Port:                22,
+			Protocol:            easyssh.PROTOCOL_TCP,
+			Password:            "123456",
+			Script:              []string{"whoami"},
+			Timeout:             5 * time.Second,
+			CommandTimeout:      10 * time.Second,
+			ConnectRetries:      2,
+			ConnectRetryBackoff: 10 * time.Millisecond,
+		},
+		Writer: &buffer,
+	}
+
+	result, err := plugin.Execute()
+	require.Error(t, err)
+	assert.Equal(t, StatusUnreachable, result.Hosts[0].Status)
+	assert.Contains(t, buffer.String(), "127.0.0.1:1: connection attempt 1/3 failed")
+	assert.Contains(t, buffer.String(), "127.0.0.1:1: connection attempt 2/3 failed")
+	assert.NotContains(t, buffer.String(), "attempt 3/3")
+}
+
+func TestConnectRetriesSkipScriptFailure(t *testing.T) {
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			Host:                []string{"localhost"},
+			Username:            "drone-scp",
+			Port:                22,
+			KeyPath:             "./tests/.ssh/id_rsa",
+			Script:              []string{"exit 1"},
+			CommandTimeout:      10 * time.Second,
+			ConnectRetries:      2,
+			ConnectRetryBackoff: 10 * time.Millisecond,
+		},
+		Writer: &buffer,
+	}
+
+	err := plugin.Exec()
+	require.Error(t, err)
+	assert.NotContains(t, buffer.String(), "connection attempt")
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stageCanary labels the hosts that run before everyone else.
+const stageCanary = "canary"
+
+// rollout runs the script on every host and returns one result per host.
+// Canary hosts go first, the others follow in batches of Config.BatchSize,
+// without it they form a single batch. Any canary failure halts the rollout,
+// a batch failure only does once it exceeds the tolerance.
+func (p Plugin) rollout(targets []target, canary []int, tol tolerance) *Result {
+	result := &Result{Hosts: make([]HostResult, len(targets))}
+
+	rest := make([]int, 0, len(targets))
+	for i := range targets {
+		if !slices.Contains(canary, i) {
+			rest = append(rest, i)
+		}
+	}
+	batches := p.batches(rest)
+
+	if len(canary) > 0 {
+		p.notice("🐤 %s: %s", stageCanary, strings.Join(hostNames(targets, canary), ", "))
+		p.runHosts(targets, stageCanary, canary, result)
+		if len(p.Config.CanaryVerify) > 0 {
+			p.verifyHosts(targets, canary, result)
+		}
+
+		if failed := result.failed(canary); len(failed) > 0 {
+			p.notice("❌ %s failed on %s, halting the rollout", stageCanary, strings.Join(failed, ", "))
+			for n, batch := range batches {
+				p.skipHosts(targets, p.batchStage(n, len(batches)), batch, result)
+			}
+			return result
+		}
+	}
+
+	for n, batch := range batches {
+		stage := p.batchStage(n, len(batches))
+		if len(batches) > 1 || len(canary) > 0 {
+			if (n > 0 || len(canary) > 0) && p.Config.BatchPause > 0 {
+				p.notice("⏸  waiting %s before %s", p.Config.BatchPause, stage)
+				time.Sleep(p.Config.BatchPause)
+			}
+			p.notice("🚀 %s: %s", stage, strings.Join(hostNames(targets, batch), ", "))
+		}
+
+		p.runHosts(targets, stage, batch, result)
+
+		failed := result.failed(batch)
+		if len(failed) > 0 && n < len(batches)-1 && !tol.allows(len(result.failed(rest)), len(p.Config.Host)) {
+			p.notice("❌ %s failed on %s, halting the rollout", stage, strings.Join(failed, ", "))
+			for m := n + 1; m < len(batches); m++ {
+				p.skipHosts(targets, p.batchStage(m, len(batches)), batches[m], result)
+			}
+			break
+		}
+	}
+
+	return result
+}
+
+// failover runs the script on the first host that can be reached, in
+// Config.Host order. Only connection and authentication failures move
+// on to the next host, a failed script is final.
+func (p Plugin) failover(targets []target) *Result {
+	result := &Result{Hosts: make([]HostResult, len(targets))}
+
+	for i, t := range targets {
+		result.Hosts[i] = p.exec(t)
+		if result.Hosts[i].Status != StatusUnreachable || i == len(targets)-1 {
+			rest := []int{}
+			for j := i + 1; j < len(targets); j++ {
+				rest = append(rest, j)
+			}
+			p.skipHosts(targets, "", rest, result)
+			break
+		}
+
+		p.notice(
+			"🔁 %s is unreachable (%v), trying %s",
+			t.Name,
+			result.Hosts[i].Err,
+			targets[i+1].Name,
+		)
+	}
+
+	return result
+}
+
+// canaryHosts returns the indexes of the canary targets: the entries of
+// Config.CanaryHosts, or the first host when only Config.Canary is set.
+// An entry resolved by Config.ResolveAll selects all of its addresses.
+func (p Plugin) canaryHosts(targets []target) ([]int, error) {
+	if len(p.Config.CanaryHosts) == 0 {
+		if p.Config.Canary {
+			return []int{0}, nil
+		}
+		return nil, nil
+	}
+
+	var indexes []int
+	for _, name := range trimValues(p.Config.CanaryHosts) {
+		found := false
+		for i, t := range targets {
+			if t.Name != name && t.Entry != name {
+				continue
+			}
+			found = true
+			if !slices.Contains(indexes, i) {
+				indexes = append(indexes, i)
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("error: canary host %q is not in the host list", name)
+		}
+	}
+
+	return indexes, nil
+}
+
+// verifyHosts runs Config.CanaryVerify on every canary host that succeeded,
+// a failed verification fails the host.
+func (p Plugin) verifyHosts(targets []target, indexes []int, result *Result) {
+	verifier := p
+	verifier.Config.Script = p.Config.CanaryVerify
+
+	for _, i := range indexes {
+		if result.Hosts[i].Err != nil {
+			continue
+		}
+
+		p.notice("🔍 verifying %s", targets[i].Name)
+		verify := verifier.exec(targets[i])
+		h := &result.Hosts[i]
+		h.Duration += verify.Duration
+		h.Stdout += verify.Stdout
+		h.Stderr += verify.Stderr
+		if verify.Err != nil {
+			h.Status = verify.Status
+			h.ExitCode = verify.ExitCode
+			h.Err = fmt.Errorf("canary verification failed: %w", verify.Err)
+		}
+	}
+}
+
+// runHosts executes the script on the given hosts, at most
+// Config.MaxParallel at a time, and waits for every one of them.
+func (p Plugin) runHosts(targets []target, stage string, indexes []int, result *Result) {
+	limit := p.Config.MaxParallel
+	if p.Config.Sync {
+		limit = 1
+	}
+	if limit <= 0 || limit > len(indexes) {
+		limit = len(indexes)
+	}
+
+	sem := make(chan struct{}, limit)
+	wg := sync.WaitGroup{}
+	for _, i := range indexes {
+		sem <- struct{}{}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			result.Hosts[i] = p.exec(targets[i])
+			result.Hosts[i].Stage = stage
+		})
+	}
+	wg.Wait()
+}
+
+// skipHosts records hosts that never ran because an earlier stage failed.
+func (p Plugin) skipHosts(targets []target, stage string, indexes []int, result *Result) {
+	for _, i := range indexes {
+		result.Hosts[i] = HostResult{
+			Host:     targets[i].Name,
+			Address:  targets[i].address(),
+			Stage:    stage,
+			Status:   StatusSkipped,
+			ExitCode: -1,
+		}
+	}
+}
+
+// batches splits the host indexes into groups of Config.BatchSize.
+func (p Plugin) batches(indexes []int) [][]int {
+	size := p.Config.BatchSize
+	if size <= 0 || size > len(indexes) {
+		size = len(indexes)
+	}
+
+	var batches [][]int
+	for start := 0; start < len(indexes); start += size {
+		batches = append(batches, indexes[start:min(start+size, len(indexes))])
+	}
+
+	return batches
+}
+
+// batchStage labels the n-th batch. A single batch has no label,
+// unless it follows the canary stage.
+func (p Plugin) batchStage(n, total int) string {
+	if total > 1 {
+		return fmt.Sprintf("batch %d/%d", n+1, total)
+	}
+	if p.Config.Canary || len(p.Config.CanaryHosts) > 0 {
+		return "rollout"
+	}
+	return ""
+}
+
+func hostNames(targets []target, indexes []int) []string {
+	names := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		names = append(names, targets[i].Name)
+	}
+	return names
+}
+
+// notice prints a rollout message that is not bound to a single host.
+func (p Plugin) notice(format string, args ...any) {
+	fmt.Fprintf(p.getWriter(), format+"\n", args...)
+}
+
+type (
+	// threshold is an absolute number of hosts or a percentage of all hosts.
+	threshold struct {
+		value   float64
+		percent bool
+	}
+
+	// tolerance decides whether a run with failed hosts still succeeds.
+	tolerance struct {
+		maxFailures *threshold
+		minSuccess  *threshold
+	}
+)
+
+// parseThreshold reads "3" or "10%". With percent set a bare number
+// is read as a percentage too. NaN and Inf are rejected.
+func parseThreshold(name, s string, percent bool) (*threshold, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, nil
+	}
+
+	t := &threshold{percent: percent}
+	if v, ok := strings.CutSuffix(s, "%"); ok {
+		s = strings.TrimSpace(v)
+		t.percent = true
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) || v < 0 || (t.percent && v > 100) {
+		return nil, fmt.Errorf("error: invalid %s value %q", name, s)
+	}
+	t.value = v
+
+	return t, nil
+}
+
+// count converts the threshold into a number of hosts out of total.
+func (t threshold) count(total int) float64 {
+	if t.percent {
+		return t.value * float64(total) / 100
+	}
+	return t.value
+}
+
+func (p Plugin) tolerance() (tolerance, error) {
+	maxFailures, err := parseThreshold("max_failures", p.Config.MaxFailures, false)
+	if err != nil {
+		return tolerance{}, err
+	}
+
+	minSuccess, err := parseThreshold("min_success", p.Config.MinSuccess, true)
+	if err != nil {
+		return tolerance{}, err
+	}
+
+	return tolerance{maxFailures: maxFailures, minSuccess: minSuccess}, nil
+}
+
+// allows reports whether failed hosts out of total are acceptable.
+// Without any threshold a single failure is too many.
+func (t tolerance) allows(failed, total int) bool {
+	if t.maxFailures == nil && t.minSuccess == nil {
+		return failed == 0
+	}
+
+	if t.maxFailures != nil && float64(failed) > t.maxFailures.count(total) {
+		return false
+	}
+
+	if t.minSuccess != nil && float64(total-failed) < t.minSuccess.count(total) {
+		return false
+	}
+
+	return true
+}
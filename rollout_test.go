@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/appleboy/easyssh-proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlugin_batches(t *testing.T) {
+	hosts := []string{"a", "b", "c", "d", "e"}
+
+	tests := []struct {
+		name string
+		size int
+		want [][]int
+	}{
+		{
+			name: "no batch size",
+			size: 0,
+			want: [][]int{{0, 1, 2, 3, 4}},
+		},
+		{
+			name: "batch size two",
+			size: 2,
+			want: [][]int{{0, 1}, {2, 3}, {4}},
+		},
+		{
+			name: "batch size larger than hosts",
+			size: 10,
+			want: [][]int{{0, 1, 2, 3, 4}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Plugin{
+				Config: Config{
+					Host:      hosts,
+					BatchSize: tt.size,
+				},
+			}
+			assert.Equal(t, tt.want, p.batches([]int{0, 1, 2, 3, 4}))
+		})
+	}
+}
+
+func TestBatchHaltsRollout(t *testing.T) {
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"127.0.0.1:1", "localhost:1", "[::1]:1"},
+			Username:       "drone-scp",
+			Port:           22,
+			Protocol:       easyssh.PROTOCOL_TCP,
+			Password:       "123456",
+			Script:         []string{"whoami"},
+			Timeout:        5 * time.Second,
+			CommandTimeout: 10 * time.Second,
+			BatchSize:      1,
+			BatchPause:     10 * time.Millisecond,
+		},
+		Writer: &buffer,
+	}
+
+	result, err := plugin.Execute()
+	require.Error(t, err)
+	require.Len(t, result.Hosts, 3)
+
+	assert.Equal(t, StatusUnreachable, result.Hosts[0].Status)
+	assert.Equal(t, "batch 1/3", result.Hosts[0].Stage)
+	assert.Equal(t, StatusSkipped, result.Hosts[1].Status)
+	assert.Equal(t, "batch 2/3", result.Hosts[1].Stage)
+	assert.Equal(t, StatusSkipped, result.Hosts[2].Status)
+	assert.Equal(t, "batch 3/3", result.Hosts[2].Stage)
+
+	var multiErr *MultiHostError
+	require.ErrorAs(t, err, &multiErr)
+	assert.Len(t, multiErr.Errors, 1)
+	assert.Contains(t, buffer.String(), "halting the rollout")
+}
+
+func TestInvalidRollout(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:        []string{"localhost"},
+			Password:    "123456",
+			MaxParallel: -1,
+		},
+	}
+
+	_, err := plugin.Execute()
+	assert.Equal(t, errInvalidRollout, err)
+}
+
+func TestMaxParallel(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"localhost", "127.0.0.1", "localhost:22", "127.0.0.1:22"},
+			Username:       "drone-scp",
+			Port:           22,
+			KeyPath:        "./tests/.ssh/id_rsa",
+			Script:         []string{"sleep 1"},
+			CommandTimeout: 60 * time.Second,
+			MaxParallel:    2,
+		},
+	}
+
+	start := time.Now()
+	err := plugin.Exec()
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 2*time.Second)
+}
+
+func TestPlugin_canaryHosts(t *testing.T) {
+	hosts := []string{"a", "b", "c"}
+	targets := []target{{Name: "a", Entry: "a"}, {Name: "b", Entry: "b"}, {Name: "c", Entry: "c"}}
+
+	p := Plugin{Config: Config{Host: hosts}}
+	canary, err := p.canaryHosts(targets)
+	require.NoError(t, err)
+	assert.Empty(t, canary)
+
+	p = Plugin{Config: Config{Host: hosts, Canary: true}}
+	canary, err = p.canaryHosts(targets)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0}, canary)
+
+	p = Plugin{Config: Config{Host: hosts, CanaryHosts: []string{"c", " b", "c"}}}
+	canary, err = p.canaryHosts(targets)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, canary)
+
+	p = Plugin{Config: Config{Host: hosts, CanaryHosts: []string{"d"}}}
+	_, err = p.canaryHosts(targets)
+	assert.Error(t, err)
+
+	// every address of a resolved host is a canary
+	resolved := []target{
+		{Name: "web(10.0.0.1)", Entry: "web"},
+		{Name: "db(10.0.0.9)", Entry: "db"},
+		{Name: "web(10.0.0.2)", Entry: "web"},
+	}
+	p = Plugin{Config: Config{CanaryHosts: []string{"web", "db(10.0.0.9)"}}}
+	canary, err = p.canaryHosts(resolved)
+	require.NoError(t, err)
+	assert.Equal(t, []int{0, 2, 1}, canary)
+}
+
+func TestCanaryHaltsRollout(t *testing.T) {
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"127.0.0.1:1", "localhost:1"},
+			Username:       "drone-scp",
+			Port:           22,
+			Protocol:       easyssh.PROTOCOL_TCP,
+			Password:       "123456",
+			Script:         []string{"whoami"},
+			Timeout:        5 * time.Second,
+			CommandTimeout: 10 * time.Second,
+			CanaryHosts:    []string{"localhost:1"},
+			Summary:        true,
+		},
+		Writer: &buffer,
+	}
+
+	result, err := plugin.Execute()
+	require.Error(t, err)
+	require.Len(t, result.Hosts, 2)
+
+	assert.Equal(t, StatusSkipped, result.Hosts[0].Status)
+	assert.Equal(t, "rollout", result.Hosts[0].Stage)
+	assert.Equal(t, StatusUnreachable, result.Hosts[1].Status)
+	assert.Equal(t, stageCanary, result.Hosts[1].Stage)
+	assert.Contains(t, buffer.String(), "canary failed on localhost:1")
+}
+
+func TestCanaryVerify(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"localhost", "127.0.0.1"},
+			Username:       "drone-scp",
+			Port:           22,
+			KeyPath:        "./tests/.ssh/id_rsa",
+			Script:         []string{"whoami"},
+			CommandTimeout: 60 * time.Second,
+			Canary:         true,
+			CanaryVerify:   []string{"exit 3"},
+		},
+	}
+
+	result, err := plugin.Execute()
+	require.Error(t, err)
+	assert.Equal(t, StatusFailed, result.Hosts[0].Status)
+	assert.Equal(t, 3, result.Hosts[0].ExitCode)
+	assert.Equal(t, StatusSkipped, result.Hosts[1].Status)
+}
+
+func TestParseThreshold(t *testing.T) {
+	th, err := parseThreshold("max_failures", "", false)
+	require.NoError(t, err)
+	assert.Nil(t, th)
+
+	th, err = parseThreshold("max_failures", "3", false)
+	require.NoError(t, err)
+	assert.Equal(t, &threshold{value: 3}, th)
+
+	th, err = parseThreshold("max_failures", " 10% ", false)
+	require.NoError(t, err)
+	assert.Equal(t, &threshold{value: 10, percent: true}, th)
+
+	th, err = parseThreshold("min_success", "90", true)
+	require.NoError(t, err)
+	assert.Equal(t, &threshold{value: 90, percent: true}, th)
+
+	for _, value := range []string{"abc", "-1", "101%", "%", "NaN", "nan%", "Inf", "+Inf", "-inf"} {
+		_, err = parseThreshold("max_failures", value, false)
+		assert.Error(t, err, value)
+		_, err = parseThreshold("min_success", value, true)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestToleranceAllows(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxFailures string
+		minSuccess  string
+		failed      int
+		total       int
+		want        bool
+	}{
+		{name: "strict without failures", failed: 0, total: 10, want: true},
+		{name: "strict with one failure", failed: 1, total: 10, want: false},
+		{name: "max failures count", maxFailures: "2", failed: 2, total: 10, want: true},
+		{name: "max failures count exceeded", maxFailures: "2", failed: 3, total: 10, want: false},
+		{name: "max failures percent", maxFailures: "10%", failed: 1, total: 10, want: true},
+		{name: "max failures percent exceeded", maxFailures: "10%", failed: 2, total: 10, want: false},
+		{name: "min success", minSuccess: "90%", failed: 1, total: 10, want: true},
+		{name: "min success exceeded", minSuccess: "90%", failed: 2, total: 10, want: false},
+		{name: "both thresholds", maxFailures: "5", minSuccess: "90", failed: 2, total: 10, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := Plugin{Config: Config{MaxFailures: tt.maxFailures, MinSuccess: tt.minSuccess}}
+			tol, err := p.tolerance()
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, tol.allows(tt.failed, tt.total))
+		})
+	}
+}
+
+func TestFailureTolerance(t *testing.T) {
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"127.0.0.1:1", "localhost:1"},
+			Username:       "drone-scp",
+			Port:           22,
+			Protocol:       easyssh.PROTOCOL_TCP,
+			Password:       "123456",
+			Script:         []string{"whoami"},
+			Timeout:        5 * time.Second,
+			CommandTimeout: 10 * time.Second,
+			MaxFailures:    "100%",
+		},
+		Writer: &buffer,
+	}
+
+	result, err := plugin.Execute()
+	require.NoError(t, err)
+	require.Error(t, result.Err())
+	assert.Contains(t, buffer.String(), "2 of 2 hosts failed, within the failure tolerance")
+	assert.Contains(t, buffer.String(), "127.0.0.1:1: ")
+	assert.Contains(t, buffer.String(), "localhost:1: ")
+
+	plugin.Config.MaxFailures = "1"
+	_, err = plugin.Execute()
+	assert.Error(t, err)
+
+	plugin.Config.MaxFailures = "many"
+	_, err = plugin.Execute()
+	assert.EqualError(t, err, `error: invalid max_failures value "many"`)
+}
+
+func TestFailureToleranceWithBatches(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"127.0.0.1:1", "localhost", "127.0.0.1"},
+			Username:       "drone-scp",
+			Port:           22,
+			Protocol:       easyssh.PROTOCOL_TCP,
+			KeyPath:        "./tests/.ssh/id_rsa",
+			Script:         []string{"whoami"},
+			CommandTimeout: 10 * time.Second,
+			BatchSize:      1,
+			MaxFailures:    "1",
+		},
+		Writer: io.Discard,
+	}
+
+	result, err := plugin.Execute()
+	require.NoError(t, err)
+	assert.Equal(t, StatusUnreachable, result.Hosts[0].Status)
+	assert.Equal(t, StatusOK, result.Hosts[1].Status)
+	assert.Equal(t, StatusOK, result.Hosts[2].Status)
+}
+
+func TestFailover(t *testing.T) {
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"127.0.0.1:1", "localhost", "127.0.0.1"},
+			Username:       "drone-scp",
+			Port:           22,
+			Protocol:       easyssh.PROTOCOL_TCP,
+			KeyPath:        "./tests/.ssh/id_rsa",
+			Script:         []string{"whoami"},
+			CommandTimeout: 10 * time.Second,
+			Failover:       true,
+		},
+		Writer: &buffer,
+	}
+
+	result, err := plugin.Execute()
+	require.NoError(t, err)
+	assert.Equal(t, StatusUnreachable, result.Hosts[0].Status)
+	assert.Equal(t, StatusOK, result.Hosts[1].Status)
+	assert.Equal(t, StatusSkipped, result.Hosts[2].Status)
+	assert.Contains(t, buffer.String(), "Successfully executed commands to localhost.")
+}
+
+func TestFailoverScriptErrorIsFinal(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"localhost", "127.0.0.1"},
+			Username:       "drone-scp",
+			Port:           22,
+			KeyPath:        "./tests/.ssh/id_rsa",
+			Script:         []string{"exit 1"},
+			CommandTimeout: 10 * time.Second,
+			Failover:       true,
+		},
+		Writer: io.Discard,
+	}
+
+	result, err := plugin.Execute()
+	require.Error(t, err)
+	assert.Equal(t, StatusFailed, result.Hosts[0].Status)
+	assert.Equal(t, StatusSkipped, result.Hosts[1].Status)
+}
+
+func TestFailoverAllUnreachable(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"127.0.0.1:1", "localhost:1"},
+			Username:       "drone-scp",
+			Port:           22,
+			Protocol:       easyssh.PROTOCOL_TCP,
+			Password:       "123456",
+			Script:         []string{"whoami"},
+			Timeout:        5 * time.Second,
+			CommandTimeout: 10 * time.Second,
+			Failover:       true,
+		},
+		Writer: io.Discard,
+	}
+
+	result, err := plugin.Execute()
+	require.Error(t, err)
+	assert.Equal(t, StatusUnreachable, result.Hosts[0].Status)
+	assert.Equal(t, StatusUnreachable, result.Hosts[1].Status)
+
+	var multiErr *MultiHostError
+	require.ErrorAs(t, err, &multiErr)
+	assert.Len(t, multiErr.Errors, 2)
+
+	plugin.Config.Canary = true
+	_, err = plugin.Execute()
+	assert.Equal(t, errInvalidFailover, err)
+}
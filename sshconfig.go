@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	easyssh "github.com/appleboy/easyssh-proxy"
+	"github.com/kevinburke/ssh_config"
+)
+
+// defaultUsername is used when neither the plugin settings
+// nor the ssh_config file name a user.
+const defaultUsername = "root"
+
+// sshConfig resolves host aliases through an OpenSSH client config file.
+type sshConfig struct {
+	config *ssh_config.Config
+}
+
+func loadSSHConfig(path string) (*sshConfig, error) {
+	f, err := os.Open(expandHome(path))
+	if err != nil {
+		return nil, fmt.Errorf("error: can't open ssh_config: %w", err)
+	}
+	defer f.Close()
+
+	config, err := ssh_config.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("error: can't parse ssh_config %s: %w", path, err)
+	}
+
+	return &sshConfig{config: config}, nil
+}
+
+// get returns the first value of key for alias, an empty string when no
+// Host block sets it.
+func (c *sshConfig) get(alias, key string) string {
+	value, _ := c.config.Get(alias, key)
+	return strings.TrimSpace(value)
+}
+
+// apply fills the settings of t that are not set explicitly: HostName,
+// User, Port, IdentityFile and ProxyJump of the matching Host blocks. A
+// ProxyJump of several hops becomes the jump hosts of t.
+func (c *sshConfig) apply(t *target) error {
+	alias := t.Alias
+
+	if hostname := c.get(alias, "HostName"); hostname != "" {
+		t.Host = strings.ReplaceAll(hostname, "%h", alias)
+	}
+
+	if t.User == "" {
+		t.User = c.get(alias, "User")
+	}
+	if t.User == "" {
+		t.User = defaultUsername
+	}
+
+	if t.Port == "" || t.Port == "0" {
+		t.Port = c.get(alias, "Port")
+	}
+	if t.Port == "" {
+		t.Port = "22"
+	}
+
+	if t.Key == "" && t.KeyPath == "" {
+		t.KeyPath = c.identityFile(alias, t.Host, t.User)
+	}
+
+	if t.Proxy.Server != "" {
+		t.Proxy = jumpDefaults(t.Proxy)
+		return nil
+	}
+	if len(t.Jumps) > 0 {
+		return nil
+	}
+
+	jump := c.get(alias, "ProxyJump")
+	if jump == "" || strings.EqualFold(jump, "none") {
+		return nil
+	}
+
+	hops := strings.Split(jump, ",")
+	if len(hops) == 1 {
+		return c.applyJump(alias, &t.Proxy, jump)
+	}
+
+	if t.Proxy.Fingerprint != "" {
+		return fmt.Errorf("%w, in the ssh_config ProxyJump of %s", errProxyJumpFingerprint, alias)
+	}
+
+	// every hop of a chain starts from the proxy settings
+	for _, hop := range hops {
+		proxy := t.Proxy
+		if err := c.applyJump(alias, &proxy, strings.TrimSpace(hop)); err != nil {
+			return err
+		}
+		t.Jumps = append(t.Jumps, proxy)
+	}
+
+	return nil
+}
+
+// applyJump turns a [user@]host[:port] ProxyJump hop of alias into proxy,
+// the jump host may itself be an alias of the file.
+func (c *sshConfig) applyJump(alias string, proxy *easyssh.DefaultConfig, jump string) error {
+	user, jumpAlias, port, err := splitUserHostPort(jump)
+	if err != nil {
+		return fmt.Errorf("error: invalid ssh_config ProxyJump %q of %s: %w", jump, alias, err)
+	}
+
+	proxy.Server = jumpAlias
+	if hostname := c.get(jumpAlias, "HostName"); hostname != "" {
+		proxy.Server = strings.ReplaceAll(hostname, "%h", jumpAlias)
+	}
+
+	// the hop itself wins over the proxy settings, which win over the file
+	if user != "" {
+		proxy.User = user
+	}
+	if proxy.User == "" {
+		proxy.User = c.get(jumpAlias, "User")
+	}
+	if port != "" {
+		proxy.Port = port
+	}
+	if proxy.Port == "" {
+		proxy.Port = c.get(jumpAlias, "Port")
+	}
+	*proxy = jumpDefaults(*proxy)
+
+	if proxy.Key == "" && proxy.KeyPath == "" {
+		proxy.KeyPath = c.identityFile(jumpAlias, proxy.Server, proxy.User)
+	}
+
+	return nil
+}
+
+// identityFile returns the first IdentityFile of alias that exists on disk.
+func (c *sshConfig) identityFile(alias, host, remoteUser string) string {
+	files, _ := c.config.GetAll(alias, "IdentityFile")
+	for _, file := range files {
+		file = expandTokens(strings.TrimSpace(file), host, remoteUser)
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+	}
+
+	return ""
+}
+
+// expandTokens replaces the ssh_config tokens allowed in IdentityFile.
+func expandTokens(s, host, remoteUser string) string {
+	localUser := ""
+	if u, err := user.Current(); err == nil {
+		localUser = u.Username
+	}
+
+	s = expandHome(s)
+	return strings.NewReplacer(
+		"%%", "%",
+		"%d", homeDir(),
+		"%h", host,
+		"%r", remoteUser,
+		"%u", localUser,
+	).Replace(s)
+}
+
+// expandHome replaces a leading ~ with the home directory.
+func expandHome(path string) string {
+	if path == "~" {
+		return homeDir()
+	}
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Join(homeDir(), path[2:])
+	}
+	return path
+}
+
+func homeDir() string {
+	home, _ := os.UserHomeDir()
+	return home
+}
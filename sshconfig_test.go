@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/appleboy/easyssh-proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSSHConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestSSHConfigTargets(t *testing.T) {
+	keyPath, err := filepath.Abs("./tests/.ssh/id_rsa")
+	require.NoError(t, err)
+
+	path := writeSSHConfig(t, `
+Host web*
+  HostName %h.prod.example.com
+  User deploy
+  Port 2222
+  IdentityFile /nonexistent/id_ed25519
+  IdentityFile `+keyPath+`
+
+Host db
+  HostName 10.0.0.5
+  ProxyJump admin@bastion:2200
+
+Host bastion
+  HostName bastion.example.com
+  User nobody
+`)
+
+	plugin := Plugin{
+		Config: Config{
+			Host:      []string{"web01", "db", "other:2022"},
+			Protocol:  easyssh.PROTOCOL_TCP,
+			Password:  "1234",
+			SSHConfig: path,
+		},
+	}
+
+	targets, err := plugin.targets()
+	require.NoError(t, err)
+	require.Len(t, targets, 3)
+
+	assert.Equal(t, "web01", targets[0].Alias)
+	assert.Equal(t, "web01.prod.example.com", targets[0].Host)
+	assert.Equal(t, "2222", targets[0].Port)
+	assert.Equal(t, "deploy", targets[0].User)
+	assert.Equal(t, keyPath, targets[0].KeyPath)
+	assert.Empty(t, targets[0].Proxy.Server)
+
+	assert.Equal(t, "10.0.0.5", targets[1].Host)
+	assert.Equal(t, "22", targets[1].Port)
+	assert.Equal(t, defaultUsername, targets[1].User)
+	assert.Equal(t, "bastion.example.com", targets[1].Proxy.Server)
+	assert.Equal(t, "2200", targets[1].Proxy.Port)
+	assert.Equal(t, "admin", targets[1].Proxy.User)
+
+	assert.Equal(t, "other", targets[2].Host)
+	assert.Equal(t, "2022", targets[2].Port)
+	assert.Equal(t, "other:2022", targets[2].address())
+}
+
+func TestSSHConfigExplicitSettings(t *testing.T) {
+	path := writeSSHConfig(t, `
+Host web
+  HostName 127.0.0.1
+  User deploy
+  Port 2222
+  IdentityFile ~/.ssh/id_rsa
+  ProxyJump bastion
+`)
+
+	plugin := Plugin{
+		Config: Config{
+			Host:      []string{"web:2200"},
+			Protocol:  easyssh.PROTOCOL_TCP,
+			Username:  "ubuntu",
+			Port:      22,
+			KeyPath:   "./tests/.ssh/id_rsa",
+			SSHConfig: path,
+			Proxy: easyssh.DefaultConfig{
+				Server: "proxy.example.com",
+				Port:   "22",
+			},
+		},
+	}
+
+	targets, err := plugin.targets()
+	require.NoError(t, err)
+
+	assert.Equal(t, "127.0.0.1", targets[0].Host)
+	assert.Equal(t, "2200", targets[0].Port)
+	assert.Equal(t, "ubuntu", targets[0].User)
+	assert.Equal(t, "./tests/.ssh/id_rsa", targets[0].KeyPath)
+	assert.Equal(t, "proxy.example.com", targets[0].Proxy.Server)
+}
+
+func TestSSHConfigErrors(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:      []string{"web"},
+			Password:  "1234",
+			SSHConfig: filepath.Join(t.TempDir(), "missing"),
+		},
+	}
+
+	_, err := plugin.Execute()
+	assert.ErrorContains(t, err, "can't open ssh_config")
+
+	plugin.Config.SSHConfig = writeSSHConfig(t, `
+Host web
+  ProxyJump b1,admin@b2:x
+`)
+	_, err = plugin.Execute()
+	assert.ErrorContains(t, err, `invalid ssh_config ProxyJump "admin@b2:x" of web`)
+
+	plugin.Config.SSHConfig = writeSSHConfig(t, `
+Host web
+  ProxyJump b1:70000
+`)
+	_, err = plugin.Execute()
+	assert.ErrorContains(t, err, `invalid ssh_config ProxyJump "b1:70000" of web: port "70000"`)
+}
+
+func TestSSHConfigProxyJumpChain(t *testing.T) {
+	path := writeSSHConfig(t, `
+Host web
+  HostName 10.0.0.5
+  ProxyJump b1,admin@b2:2222
+
+Host b1
+  HostName bastion.example.com
+  User jump
+`)
+
+	plugin := Plugin{
+		Config: Config{
+			Host:      []string{"web"},
+			Password:  "1234",
+			SSHConfig: path,
+			Proxy:     easyssh.DefaultConfig{Password: "proxy", Fingerprint: "SHA256:x"},
+		},
+	}
+
+	_, err := plugin.targets()
+	assert.ErrorIs(t, err, errProxyJumpFingerprint)
+
+	plugin.Config.Proxy.Fingerprint = ""
+	targets, err := plugin.targets()
+	require.NoError(t, err)
+	require.Len(t, targets[0].Jumps, 2)
+
+	hops := targets[0].hops()
+	assert.Equal(t, "bastion.example.com:22", hops[0].address())
+	assert.Equal(t, "jump", hops[0].User)
+	assert.Equal(t, "b2:2222", hops[1].address())
+	assert.Equal(t, "admin", hops[1].User)
+	assert.Equal(t, "proxy", hops[1].Password)
+	assert.Empty(t, hops[1].Fingerprint)
+}
+
+func TestSSHConfigProxyJumpSettings(t *testing.T) {
+	path := writeSSHConfig(t, `
+Host web
+  ProxyJump bastion
+
+Host bastion
+  HostName bastion.example.com
+  User nobody
+  Port 2200
+`)
+
+	plugin := Plugin{
+		Config: Config{
+			Host:      []string{"web"},
+			Password:  "1234",
+			SSHConfig: path,
+		},
+	}
+
+	targets, err := plugin.targets()
+	require.NoError(t, err)
+	assert.Equal(t, "nobody", targets[0].Proxy.User)
+	assert.Equal(t, "2200", targets[0].Proxy.Port)
+
+	plugin.Config.Proxy = easyssh.DefaultConfig{User: "ops", Port: "2022"}
+	targets, err = plugin.targets()
+	require.NoError(t, err)
+	assert.Equal(t, "bastion.example.com", targets[0].Proxy.Server)
+	assert.Equal(t, "ops", targets[0].Proxy.User)
+	assert.Equal(t, "2022", targets[0].Proxy.Port)
+
+	plugin.Config.Proxy = easyssh.DefaultConfig{Server: "proxy.example.com"}
+	targets, err = plugin.targets()
+	require.NoError(t, err)
+	assert.Equal(t, defaultUsername, targets[0].Proxy.User)
+	assert.Equal(t, "22", targets[0].Proxy.Port)
+}
+
+func TestExpandTokens(t *testing.T) {
+	home := homeDir()
+
+	assert.Equal(t, filepath.Join(home, ".ssh/id_rsa"), expandTokens("~/.ssh/id_rsa", "foo", "bar"))
+	assert.Equal(t, home+"/keys/foo-bar", expandTokens("%d/keys/%h-%r", "foo", "bar"))
+	assert.Equal(t, "/keys/100%", expandTokens("/keys/100%%", "foo", "bar"))
+}
+
+func TestSSHConfigIdentityFileOnly(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	keyPath, err := filepath.Abs("./tests/.ssh/id_rsa")
+	require.NoError(t, err)
+
+	path := writeSSHConfig(t, `
+Host web
+  HostName 127.0.0.1
+  Port 22
+  User drone-scp
+  IdentityFile `+keyPath+`
+
+Host db
+  HostName 127.0.0.1
+`)
+
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"web"},
+			Protocol:       easyssh.PROTOCOL_TCP,
+			SSHConfig:      path,
+			Script:         []string{"echo identity"},
+			CommandTimeout: 10 * time.Second,
+		},
+		Writer: &buffer,
+	}
+
+	require.NoError(t, plugin.Exec())
+	assert.Contains(t, buffer.String(), "identity")
+
+	// a Host block without credentials still fails before any dial
+	plugin.Config.Host = []string{"web", "db"}
+	_, err = plugin.Execute()
+	assert.ErrorIs(t, err, errMissingPasswordOrKey)
+	assert.ErrorContains(t, err, "for db")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HostStatus is the outcome of a single host.
+type HostStatus string
+
+const (
+	// StatusOK means the script exited with status zero.
+	StatusOK HostStatus = "ok"
+	// StatusFailed means the script ran but returned an error.
+	StatusFailed HostStatus = "failed"
+	// StatusTimeout means the script exceeded Config.CommandTimeout.
+	StatusTimeout HostStatus = "timeout"
+	// StatusUnreachable means the connection or authentication failed.
+	StatusUnreachable HostStatus = "unreachable"
+	// StatusSkipped means the host never ran because an earlier stage failed.
+	StatusSkipped HostStatus = "skipped"
+)
+
+type (
+	// HostResult is the outcome of running the script on a single host.
+	HostResult struct {
+		// Host is the entry of Config.Host.
+		Host string
+		// Address is the resolved host:port.
+		Address string
+		// Stage names the rollout step the host ran in, empty for a plain run.
+		Stage  string
+		Status HostStatus
+		// ExitCode is the remote exit code, -1 when the server did not report one.
+		ExitCode int
+		Duration time.Duration
+		// Stdout and Stderr are the number of bytes received on each stream.
+		Stdout int64
+		Stderr int64
+		Err    error
+	}
+
+	// Result is the outcome of a run, one entry per host in Config.Host order.
+	Result struct {
+		Hosts []HostResult
+	}
+)
+
+// newHostResult fills the status and exit code from the error of a finished host.
+func newHostResult(r HostResult, err error) HostResult {
+	r.Err = err
+	r.ExitCode = -1
+
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		r.Status = StatusOK
+		r.ExitCode = 0
+	case errors.Is(err, errCommandTimeOut):
+		r.Status = StatusTimeout
+	case errors.As(err, &exitErr):
+		r.Status = StatusFailed
+		r.ExitCode = exitErr.ExitStatus()
+	default:
+		r.Status = StatusFailed
+	}
+
+	return r
+}
+
+// Err returns the combined error of every failed host, or nil.
+func (r *Result) Err() error {
+	var errs []*HostError
+	for _, h := range r.Hosts {
+		if h.Err != nil {
+			errs = append(errs, &HostError{Host: h.Host, Err: h.Err})
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return &MultiHostError{Errors: errs}
+}
+
+// failed returns the hosts among indexes that did not succeed.
+func (r *Result) failed(indexes []int) []string {
+	var hosts []string
+	for _, i := range indexes {
+		if r.Hosts[i].Err != nil {
+			hosts = append(hosts, r.Hosts[i].Host)
+		}
+	}
+	return hosts
+}
+
+// succeeded returns the first host that succeeded, or an empty string.
+func (r *Result) succeeded() string {
+	for _, h := range r.Hosts {
+		if h.Status == StatusOK {
+			return h.Host
+		}
+	}
+	return ""
+}
+
+// notOK counts the hosts that did not succeed, skipped hosts included.
+func (r *Result) notOK() int {
+	count := 0
+	for _, h := range r.Hosts {
+		if h.Status != StatusOK {
+			count++
+		}
+	}
+	return count
+}
+
+// WriteTable prints one row per host. The STAGE column is only
+// present when the run was split into stages.
+func (r *Result) WriteTable(w io.Writer) error {
+	withStage := false
+	for _, h := range r.Hosts {
+		if h.Stage != "" {
+			withStage = true
+			break
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if withStage {
+		fmt.Fprint(tw, "STAGE\t")
+	}
+	fmt.Fprintln(tw, "HOST\tADDRESS\tSTATUS\tEXIT\tDURATION\tSTDOUT\tSTDERR")
+	for _, h := range r.Hosts {
+		exitCode := "-"
+		if h.ExitCode >= 0 {
+			exitCode = strconv.Itoa(h.ExitCode)
+		}
+		if withStage {
+			fmt.Fprintf(tw, "%s\t", h.Stage)
+		}
+		fmt.Fprintf(
+			tw,
+			"%s\t%s\t%s\t%s\t%s\t%d B\t%d B\n",
+			h.Host,
+			h.Address,
+			h.Status,
+			exitCode,
+			h.Duration.Round(time.Millisecond),
+			h.Stdout,
+			h.Stderr,
+		)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appleboy/easyssh-proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHostResult(t *testing.T) {
+	r := newHostResult(HostResult{Host: "foo"}, nil)
+	assert.Equal(t, StatusOK, r.Status)
+	assert.Equal(t, 0, r.ExitCode)
+	assert.NoError(t, r.Err)
+
+	r = newHostResult(HostResult{Host: "foo"}, errCommandTimeOut)
+	assert.Equal(t, StatusTimeout, r.Status)
+	assert.Equal(t, -1, r.ExitCode)
+
+	r = newHostResult(HostResult{Host: "foo"}, errors.New("boom"))
+	assert.Equal(t, StatusFailed, r.Status)
+	assert.Equal(t, -1, r.ExitCode)
+}
+
+func TestResultWriteTable(t *testing.T) {
+	var buffer bytes.Buffer
+	result := &Result{
+		Hosts: []HostResult{
+			{
+				Host:     "foo.com",
+				Address:  "foo.com:22",
+				Status:   StatusOK,
+				Duration: 1500 * time.Millisecond,
+				Stdout:   12,
+			},
+			{
+				Host:     "bar.com:2222",
+				Address:  "bar.com:2222",
+				Status:   StatusUnreachable,
+				ExitCode: -1,
+				Duration: 20 * time.Millisecond,
+			},
+		},
+	}
+
+	require.NoError(t, result.WriteTable(&buffer))
+
+	expected := `
+HOST          ADDRESS       STATUS       EXIT  DURATION  STDOUT  STDERR
+foo.com       foo.com:22    ok           0     1.5s      12 B    0 B
+bar.com:2222  bar.com:2222  unreachable  -     20ms      0 B     0 B
+`
+	assert.Equal(t, strings.TrimSpace(expected), strings.TrimSpace(buffer.String()))
+}
+
+func TestExecuteResult(t *testing.T) {
+	var buffer bytes.Buffer
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"127.0.0.1:1", "", "localhost:1"},
+			Username:       "drone-scp",
+			Port:           22,
+			Protocol:       easyssh.PROTOCOL_TCP,
+			Password:       "123456",
+			Script:         []string{"whoami"},
+			Timeout:        5 * time.Second,
+			CommandTimeout: 10 * time.Second,
+			Summary:        true,
+		},
+		Writer: &buffer,
+	}
+
+	result, err := plugin.Execute()
+	require.Error(t, err)
+	require.NotNil(t, result)
+	require.Len(t, result.Hosts, 2)
+
+	assert.Equal(t, "127.0.0.1:1", result.Hosts[0].Host)
+	assert.Equal(t, "127.0.0.1:1", result.Hosts[0].Address)
+	assert.Equal(t, StatusUnreachable, result.Hosts[0].Status)
+	assert.Equal(t, -1, result.Hosts[0].ExitCode)
+	assert.Equal(t, "localhost:1", result.Hosts[1].Host)
+	assert.Equal(t, StatusUnreachable, result.Hosts[1].Status)
+
+	assert.Contains(t, buffer.String(), "HOST")
+	assert.Contains(t, buffer.String(), "unreachable")
+}
+
+func TestExecuteResultSuccess(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:           []string{"localhost", "127.0.0.1"},
+			Username:       "drone-scp",
+			Port:           22,
+			KeyPath:        "./tests/.ssh/id_rsa",
+			Script:         []string{"echo foo", "echo bar >&2"},
+			CommandTimeout: 60 * time.Second,
+		},
+		Writer: io.Discard,
+	}
+
+	result, err := plugin.Execute()
+	require.NoError(t, err)
+	require.Len(t, result.Hosts, 2)
+
+	for _, h := range result.Hosts {
+		assert.Equal(t, StatusOK, h.Status)
+		assert.Equal(t, 0, h.ExitCode)
+		assert.Equal(t, int64(4), h.Stdout)
+		assert.Equal(t, int64(4), h.Stderr)
+	}
+	assert.Equal(t, "localhost:22", result.Hosts[0].Address)
+	assert.Equal(t, "127.0.0.1:22", result.Hosts[1].Address)
+}
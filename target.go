@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	easyssh "github.com/appleboy/easyssh-proxy"
+	"golang.org/x/crypto/ssh"
+)
+
+// target is one entry of Config.Host with every connection setting resolved.
+type target struct {
+	// Name is the entry of Config.Host the target comes from.
+	Name string
+	// Entry is Name without the address Config.ResolveAll appends, every
+	// address of a host shares it.
+	Entry string
+	// Alias is the host part of Name, it prefixes the log lines.
+	Alias string
+
+	Host string
+	// IP is the address dialed instead of Host, set by Config.ResolveAll.
+	IP              string
+	Port            string
+	User            string
+	Password        string
+	Key             string
+	KeyPath         string
+	Keys            []string
+	KeyPaths        []string
+	Passphrase      string
+	Fingerprint     string
+	Cert            *ssh.Certificate
+	HostCAKeys      []ssh.PublicKey
+	Answers         []answer
+	Proxy           easyssh.DefaultConfig
+	ProxyCert       *ssh.Certificate
+	ProxyHostCAKeys []ssh.PublicKey
+	ProxyAnswers    []answer
+	// Envs are the variables exported on this host only.
+	Envs map[string]string
+	// ProxyCommand carries the connection of the first hop.
+	ProxyCommand string
+	// Jumps are the jump hosts of Config.ProxyJump, in the order they
+	// are dialed, they replace Proxy when set.
+	Jumps []easyssh.DefaultConfig
+
+	// hostKeys verifies the host keys of the target and its proxy,
+	// nil without known_hosts settings.
+	hostKeys *knownHosts
+	// networkProxy tunnels the first SSH hop, nil without network_proxy.
+	networkProxy *networkProxy
+}
+
+// targets resolves every entry of Config.Host and Config.HostList,
+// before any dial.
+func (p Plugin) targets() ([]target, error) {
+	specs, err := p.hostSpecs()
+	if err != nil {
+		return nil, err
+	}
+	p.Config.Host = make([]string, 0, len(specs))
+	for _, spec := range specs {
+		p.Config.Host = append(p.Config.Host, spec.Name)
+	}
+
+	var sshConfig *sshConfig
+	if p.Config.SSHConfig != "" {
+		var err error
+		if sshConfig, err = loadSSHConfig(p.Config.SSHConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	hostKeys, err := p.knownHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	fingerprints, err := p.fingerprints()
+	if err != nil {
+		return nil, err
+	}
+
+	keyPaths, err := expandKeyPaths(p.Config.KeyPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := loadCert("ssh_cert", p.Config.Cert, p.Config.CertPath)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyCert, err := loadCert("proxy_ssh_cert", p.Config.ProxyCert, p.Config.ProxyCertPath)
+	if err != nil {
+		return nil, err
+	}
+
+	hostCAKeys, err := parseHostCAKeys("host_ca_key", p.Config.HostCAKey)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyHostCAKeys, err := parseHostCAKeys("proxy_host_ca_key", p.Config.ProxyHostCAKey)
+	if err != nil {
+		return nil, err
+	}
+
+	answers, err := parseAnswers("keyboard_interactive", p.Config.KeyboardInteractive)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyAnswers, err := parseAnswers("proxy_keyboard_interactive", p.Config.ProxyKeyboardInteractive)
+	if err != nil {
+		return nil, err
+	}
+
+	jumps, err := parseProxyJump(p.Config.ProxyJump, p.Config.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	if len(jumps) > 0 && p.Config.Proxy.Server != "" {
+		return nil, errProxyJumpWithProxy
+	}
+
+	networkProxy, err := parseNetworkProxy(p.Config.NetworkProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyCommand := strings.TrimSpace(p.Config.ProxyCommand)
+	if err := checkProxyCommand(proxyCommand); err != nil {
+		return nil, err
+	}
+	if proxyCommand != "" && networkProxy != nil {
+		return nil, errProxyCommandWithNetworkProxy
+	}
+
+	targets := make([]target, 0, len(specs))
+	for i, spec := range specs {
+		t := target{
+			Name:            spec.Name,
+			Entry:           spec.Name,
+			Alias:           spec.Host,
+			Host:            spec.Host,
+			Port:            spec.Port,
+			User:            spec.User,
+			Password:        p.Config.Password,
+			Key:             p.Config.Key,
+			KeyPath:         p.Config.KeyPath,
+			Keys:            trimValues(p.Config.Keys),
+			KeyPaths:        keyPaths,
+			Passphrase:      p.Config.Passphrase,
+			Fingerprint:     fingerprints[i],
+			Cert:            cert,
+			HostCAKeys:      hostCAKeys,
+			Answers:         answers,
+			Proxy:           p.Config.Proxy,
+			ProxyCert:       proxyCert,
+			ProxyHostCAKeys: proxyHostCAKeys,
+			ProxyAnswers:    proxyAnswers,
+			ProxyCommand:    proxyCommand,
+			Jumps:           jumps,
+			Envs:            spec.Envs,
+			hostKeys:        hostKeys,
+			networkProxy:    networkProxy,
+		}
+		if err := p.applySpec(&t, spec); err != nil {
+			return nil, err
+		}
+
+		if sshConfig != nil {
+			if err := sshConfig.apply(&t); err != nil {
+				return nil, err
+			}
+		}
+
+		if p.Config.ResolveAll {
+			resolved, err := p.resolveAll(t)
+			if err != nil {
+				return nil, err
+			}
+			targets = append(targets, resolved...)
+			continue
+		}
+
+		targets = append(targets, t)
+	}
+
+	return targets, nil
+}
+
+// fingerprints returns the expected host key fingerprint of every entry
+// of Config.Host. Config.Fingerprints holds either host=SHA256:... entries
+// or a list aligned with Config.Host, hosts it leaves out fall back to
+// Config.Fingerprint.
+func (p Plugin) fingerprints() ([]string, error) {
+	fingerprints := make([]string, len(p.Config.Host))
+	for i := range fingerprints {
+		fingerprints[i] = strings.TrimSpace(p.Config.Fingerprint)
+	}
+
+	entries := trimValues(p.Config.Fingerprints)
+	if len(entries) == 0 {
+		return fingerprints, nil
+	}
+
+	keyed := strings.Contains(entries[0], "=")
+	for _, entry := range entries {
+		if strings.Contains(entry, "=") != keyed {
+			return nil, errMixedFingerprints
+		}
+	}
+
+	if !keyed {
+		if len(entries) != len(p.Config.Host) {
+			return nil, fmt.Errorf(
+				"error: fingerprints has %d entries for %d hosts",
+				len(entries),
+				len(p.Config.Host),
+			)
+		}
+		return entries, nil
+	}
+
+	for _, entry := range entries {
+		name, fingerprint, _ := strings.Cut(entry, "=")
+		name, fingerprint = strings.TrimSpace(name), strings.TrimSpace(fingerprint)
+
+		found := false
+		for i, host := range p.Config.Host {
+			if _, alias, _, _ := p.splitHost(host); host == name || alias == name {
+				fingerprints[i] = fingerprint
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("error: fingerprint host %q is not in the host list", name)
+		}
+	}
+
+	return fingerprints, nil
+}
+
+// applySpec applies the settings spec overrides, the plugin settings are
+// kept for the others.
+func (p Plugin) applySpec(t *target, spec hostSpec) error {
+	if t.User == "" {
+		t.User = p.Config.Username
+	}
+
+	if spec.Password != "" {
+		t.Password = spec.Password
+	}
+	if spec.Key != "" {
+		t.Key = spec.Key
+	}
+	if spec.KeyPath != "" {
+		t.KeyPath = spec.KeyPath
+	}
+	if spec.Fingerprint != "" {
+		t.Fingerprint = spec.Fingerprint
+	}
+
+	if spec.Proxy != nil {
+		proxy := strings.TrimSpace(*spec.Proxy)
+		t.Proxy.Server = ""
+		t.Jumps = nil
+		if proxy != "" && !strings.EqualFold(proxy, "none") {
+			jumps, err := parseProxyJump(proxy, p.Config.Proxy)
+			if err != nil {
+				return fmt.Errorf("%w, in the proxy of host %s", err, spec.Name)
+			}
+			t.Jumps = jumps
+		}
+	}
+
+	return nil
+}
+
+// address returns the host:port the target connects to.
+func (t target) address() string {
+	if t.IP != "" {
+		return net.JoinHostPort(t.IP, t.Port)
+	}
+	return net.JoinHostPort(t.Host, t.Port)
+}
+
+// endpoint returns the connection settings of the target itself.
+func (t target) endpoint(config Config) endpoint {
+	return endpoint{
+		Host:              t.Host,
+		IP:                t.IP,
+		Port:              t.Port,
+		User:              t.User,
+		Password:          t.Password,
+		Key:               t.Key,
+		KeyPath:           t.KeyPath,
+		Keys:              t.Keys,
+		KeyPaths:          t.KeyPaths,
+		Passphrase:        t.Passphrase,
+		Fingerprint:       t.Fingerprint,
+		Cert:              t.Cert,
+		HostCAKeys:        t.HostCAKeys,
+		Answers:           t.Answers,
+		Protocol:          config.Protocol,
+		Timeout:           config.Timeout,
+		Ciphers:           config.Ciphers,
+		UseInsecureCipher: config.UseInsecureCipher,
+		AgentForward:      config.AgentForward,
+	}
+}
+
+// hops returns the connection settings of the jump hosts of the target,
+// the one closest to the runner first.
+func (t target) hops() []endpoint {
+	if len(t.Jumps) > 0 {
+		hops := make([]endpoint, 0, len(t.Jumps))
+		for _, jump := range t.Jumps {
+			hops = append(hops, t.proxyEndpoint(jump))
+		}
+		return hops
+	}
+
+	if t.Proxy.Server != "" {
+		return []endpoint{t.proxyEndpoint(t.Proxy)}
+	}
+
+	return nil
+}
+
+// proxyEndpoint returns the connection settings of the jump host proxy.
+func (t target) proxyEndpoint(proxy easyssh.DefaultConfig) endpoint {
+	return endpoint{
+		Host:              proxy.Server,
+		Port:              proxy.Port,
+		User:              proxy.User,
+		Password:          proxy.Password,
+		Key:               proxy.Key,
+		KeyPath:           proxy.KeyPath,
+		Passphrase:        proxy.Passphrase,
+		Fingerprint:       proxy.Fingerprint,
+		Cert:              t.ProxyCert,
+		HostCAKeys:        t.ProxyHostCAKeys,
+		Answers:           t.ProxyAnswers,
+		Protocol:          proxy.Protocol,
+		Timeout:           proxy.Timeout,
+		Ciphers:           proxy.Ciphers,
+		UseInsecureCipher: proxy.UseInsecureCipher,
+	}
+}
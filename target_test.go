@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/appleboy/easyssh-proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprints(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:        []string{"foo.com", "bar.com:2222", "baz.com"},
+			Protocol:    easyssh.PROTOCOL_TCP,
+			Fingerprint: "SHA256:global",
+			Fingerprints: []string{
+				"foo.com=SHA256:foo",
+				" bar.com = SHA256:bar ",
+			},
+		},
+	}
+
+	fingerprints, err := plugin.fingerprints()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SHA256:foo", "SHA256:bar", "SHA256:global"}, fingerprints)
+
+	plugin.Config.Fingerprints = []string{"SHA256:1", "SHA256:2", "", "SHA256:3"}
+	fingerprints, err = plugin.fingerprints()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SHA256:1", "SHA256:2", "SHA256:3"}, fingerprints)
+
+	plugin.Config.Fingerprints = nil
+	fingerprints, err = plugin.fingerprints()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"SHA256:global", "SHA256:global", "SHA256:global"}, fingerprints)
+}
+
+func TestFingerprintsErrors(t *testing.T) {
+	plugin := Plugin{
+		Config: Config{
+			Host:         []string{"foo.com", "bar.com"},
+			Password:     "1234",
+			Fingerprints: []string{"SHA256:1"},
+		},
+	}
+
+	_, err := plugin.Execute()
+	assert.ErrorContains(t, err, "fingerprints has 1 entries for 2 hosts")
+
+	plugin.Config.Fingerprints = []string{"foo.com=SHA256:1", "SHA256:2"}
+	_, err = plugin.Execute()
+	assert.Equal(t, errMixedFingerprints, err)
+
+	plugin.Config.Fingerprints = []string{"qux.com=SHA256:1"}
+	_, err = plugin.Execute()
+	assert.ErrorContains(t, err, `fingerprint host "qux.com" is not in the host list`)
+}
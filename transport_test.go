@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appleboy/easyssh-proxy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// transportServer is an in-process SSH server accepting any key and the
+// password "secret": exec requests echo the command and whether a pty was
+// requested, "hang" never answers, and direct-tcpip channels are forwarded
+// except to the blackhole address.
+type transportServer struct {
+	addr    string
+	hostKey ssh.PublicKey
+
+	mu       sync.Mutex
+	forwards []string
+}
+
+const transportBlackhole = "192.0.2.1:22"
+
+func newTransportServer(t *testing.T, ciphers ...string) *transportServer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(_ ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "secret" {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.Ciphers = ciphers
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	s := &transportServer{addr: listener.Addr().String(), hostKey: signer.PublicKey()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+func (s *transportServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go s.session(channel, requests)
+		case "direct-tcpip":
+			var target struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			_ = ssh.Unmarshal(newChannel.ExtraData(), &target)
+			address := net.JoinHostPort(target.Host, fmt.Sprint(target.Port))
+			s.mu.Lock()
+			s.forwards = append(s.forwards, address)
+			s.mu.Unlock()
+			if address == transportBlackhole {
+				// neither accepted nor rejected, the dial has to time out
+				continue
+			}
+			go forward(newChannel, address)
+		default:
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func (s *transportServer) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	pty := false
+	for req := range requests {
+		switch req.Type {
+		case "pty-req":
+			pty = true
+			_ = req.Reply(true, nil)
+		case "exec":
+			_ = req.Reply(true, nil)
+			var payload struct{ Command string }
+			_ = ssh.Unmarshal(req.Payload, &payload)
+			if strings.Contains(payload.Command, "hang") {
+				continue
+			}
+			fmt.Fprintf(channel, "pty=%t\n%s\n", pty, payload.Command)
+			status := make([]byte, 4)
+			binary.BigEndian.PutUint32(status, 0)
+			_, _ = channel.SendRequest("exit-status", false, status)
+			channel.Close()
+			return
+		default:
+			_ = req.Reply(false, nil)
+		}
+	}
+}
+
+func forward(newChannel ssh.NewChannel, address string) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	go func() {
+		_, _ = io.Copy(conn, channel)
+		conn.Close()
+	}()
+	_, _ = io.Copy(channel, conn)
+	channel.Close()
+}
+
+func (s *transportServer) forwarded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.forwards...)
+}
+
+func transportPlugin(t *testing.T, addr string, buffer *bytes.Buffer) Plugin {
+	t.Helper()
+
+	host, port, err := net.SplitHostPort(addr)
+	require.NoError(t, err)
+	number, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	return Plugin{
+		Config: Config{
+			Host:           []string{host},
+			Username:       "deploy",
+			Password:       "secret",
+			Port:           number,
+			Script:         []string{"echo hello"},
+			Timeout:        5 * time.Second,
+			CommandTimeout: 5 * time.Second,
+		},
+		Writer: buffer,
+	}
+}
+
+func TestTransportRequireTty(t *testing.T) {
+	server := newTransportServer(t)
+
+	var buffer bytes.Buffer
+	plugin := transportPlugin(t, server.addr, &buffer)
+	require.NoError(t, plugin.Exec())
+	assert.Contains(t, buffer.String(), "pty=false")
+	assert.Contains(t, buffer.String(), "echo hello")
+
+	buffer.Reset()
+	plugin.Config.RequireTty = true
+	require.NoError(t, plugin.Exec())
+	assert.Contains(t, buffer.String(), "pty=true")
+}
+
+func TestTransportCiphers(t *testing.T) {
+	server := newTransportServer(t, "aes256-ctr")
+
+	var buffer bytes.Buffer
+	plugin := transportPlugin(t, server.addr, &buffer)
+	plugin.Config.Ciphers = []string{"aes256-ctr"}
+	require.NoError(t, plugin.Exec())
+	assert.Contains(t, buffer.String(), "echo hello")
+
+	plugin.Config.Ciphers = []string{"aes128-ctr"}
+	assert.ErrorContains(t, plugin.Exec(), "no common algorithm")
+
+	cbc := newTransportServer(t, "aes128-cbc")
+	plugin = transportPlugin(t, cbc.addr, &buffer)
+	assert.ErrorContains(t, plugin.Exec(), "no common algorithm")
+	plugin.Config.UseInsecureCipher = true
+	assert.NoError(t, plugin.Exec())
+}
+
+func TestTransportProxy(t *testing.T) {
+	server := newTransportServer(t)
+	proxy := newTransportServer(t)
+	host, port, err := net.SplitHostPort(proxy.addr)
+	require.NoError(t, err)
+
+	var buffer bytes.Buffer
+	plugin := transportPlugin(t, server.addr, &buffer)
+	plugin.Config.Proxy = easyssh.DefaultConfig{
+		Server:   host,
+		Port:     port,
+		User:     "jump",
+		Password: "secret",
+		Timeout:  5 * time.Second,
+	}
+	require.NoError(t, plugin.Exec())
+	assert.Contains(t, buffer.String(), "echo hello")
+	assert.Equal(t, []string{server.addr}, proxy.forwarded())
+
+	plugin.Config.Host = []string{"192.0.2.1"}
+	plugin.Config.Port = 22
+	plugin.Config.Timeout = 500 * time.Millisecond
+	start := time.Now()
+	err = plugin.Exec()
+	assert.ErrorContains(t, err, easyssh.ErrProxyDialTimeout.Error())
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestTransportCommandTimeout(t *testing.T) {
+	server := newTransportServer(t)
+
+	var buffer bytes.Buffer
+	plugin := transportPlugin(t, server.addr, &buffer)
+	plugin.Config.Script = []string{"hang"}
+	plugin.Config.CommandTimeout = 500 * time.Millisecond
+
+	start := time.Now()
+	assert.ErrorContains(t, plugin.Exec(), "timeout")
+	assert.Less(t, time.Since(start), 5*time.Second)
+}